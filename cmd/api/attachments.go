@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+	"github.com/vynquoc/cs-flash-cards/internal/validator"
+)
+
+// attachmentGCBatchSize is how many unlinked attachments are collected on
+// each run of the attachment collector.
+const attachmentGCBatchSize = 100
+
+func (app *application) listCardAttachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	attachments, err := app.models.Attachments.GetForCard(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	for _, attachment := range attachments {
+		app.setAttachmentURLs(attachment)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"attachments": attachments}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// newAttachment describes a stored upload as an attachment, ready to be
+// registered.
+func newAttachment(r *http.Request, blob *storedBlob) *data.Attachment {
+	return &data.Attachment{
+		Key:         blob.Key,
+		ContentType: blob.ContentType,
+		Size:        blob.Size,
+		SHA256:      blob.SHA256,
+		UploadedBy:  clientIP(r),
+	}
+}
+
+// registerAttachment records a stored upload, so that cards whose content
+// links to it can be tracked and it can be collected once nothing does.
+func (app *application) registerAttachment(attachment *data.Attachment) error {
+	err := app.models.Attachments.Insert(attachment)
+	if err != nil {
+		return err
+	}
+	app.setAttachmentURLs(attachment)
+	return nil
+}
+
+func (app *application) setAttachmentURLs(attachment *data.Attachment) {
+	attachment.URL = app.blobs.URL(attachment.Key)
+	attachment.VariantURLs = make(map[string]string, len(attachment.Variants))
+	for name, key := range attachment.Variants {
+		attachment.VariantURLs[name] = app.blobs.URL(key)
+	}
+}
+
+// attachmentForURL returns the attachment that a media URL points at, or
+// data.ErrRecordNotFound if it doesn't point at one.
+func (app *application) attachmentForURL(url string) (*data.Attachment, error) {
+	keys := data.MediaKeys(url)
+	if len(keys) != 1 {
+		return nil, data.ErrRecordNotFound
+	}
+	return app.models.Attachments.GetByKey(keys[0])
+}
+
+// resolveCardAudio checks that the card's audio URL points at an uploaded
+// audio file, and fills in the file's canonical URL and duration.
+func (app *application) resolveCardAudio(v *validator.Validator, card *data.Card) error {
+	if card.Audio == nil || card.Audio.URL == "" {
+		return nil
+	}
+	attachment, err := app.attachmentForURL(card.Audio.URL)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("audio.url", "must be the URL of an uploaded audio file")
+			return nil
+		default:
+			return err
+		}
+	}
+	if attachment.DurationMS == nil {
+		v.AddError("audio.url", "must be the URL of an uploaded audio file")
+		return nil
+	}
+
+	card.Audio.URL = app.blobs.URL(attachment.Key)
+	card.Audio.DurationMS = *attachment.DurationMS
+	return nil
+}
+
+// audioUpdate returns the audio to set on a card from a partial update, in
+// which an empty URL removes the card's audio.
+func audioUpdate(audio *data.CardAudio) *data.CardAudio {
+	if audio.URL == "" {
+		return nil
+	}
+	return audio
+}
+
+// clientIP returns the address of the client that sent the request.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// startAttachmentCollector deletes attachments, and the blobs behind them,
+// once no card has linked to them for the configured retention period.
+func (app *application) startAttachmentCollector() {
+	app.runPeriodically(app.config.attachments.gcInterval, func() {
+		cutoff := time.Now().Add(-app.config.attachments.retention)
+		attachments, err := app.models.Attachments.GetUnlinked(cutoff, attachmentGCBatchSize)
+		if err != nil {
+			app.logger.Error(err.Error())
+			return
+		}
+
+		collected := 0
+		for _, attachment := range attachments {
+			keys, err := app.models.Attachments.DeleteUnlinked(attachment, cutoff)
+			if err != nil {
+				if !errors.Is(err, data.ErrRecordNotFound) {
+					app.logger.Error(err.Error())
+				}
+				continue
+			}
+			for _, key := range keys {
+				err := app.blobs.Delete(key)
+				if err != nil {
+					app.logger.Error(err.Error(), "key", key)
+				}
+			}
+			collected++
+		}
+		if collected > 0 {
+			app.logger.Info("collected unused attachments", "count", collected)
+		}
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+	"github.com/vynquoc/cs-flash-cards/internal/validator"
+)
+
+// maxBatchBytes caps the body of a batch create request.
+const maxBatchBytes = 32 << 20
+
+type batchCardInput struct {
+	Title       string            `json:"title"`
+	Tags        []string          `json:"tags"`
+	Content     string            `json:"content"`
+	CodeSnippet *data.CodeSnippet `json:"code_snippet"`
+	Description string            `json:"description"`
+	Audio       *data.CardAudio   `json:"audio"`
+}
+
+// batchCreateCardsHandler accepts either a JSON array of cards or an NDJSON
+// stream (Content-Type: application/x-ndjson). Valid cards are inserted
+// together; invalid ones are reported by their index in the request.
+func (app *application) batchCreateCardsHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBytes)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	ndjson := mediaType == "application/x-ndjson" || mediaType == "application/ndjson"
+
+	nextReviewDate := app.calculateReviewDate(time.Now().Truncate(24*time.Hour), 1)
+
+	// Cards are decoded and validated as they are read, so only the valid
+	// ones are held in memory.
+	cards := []*data.Card{}
+	indexes := []int{}
+	failures := map[string]map[string]string{}
+	var resolveErr error
+	count, err := app.readBatch(r.Body, ndjson, func(i int, raw json.RawMessage) error {
+		var input batchCardInput
+
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&input)
+		if err != nil {
+			failures[strconv.Itoa(i)] = map[string]string{"body": err.Error()}
+			return nil
+		}
+
+		card := &data.Card{
+			Title:          input.Title,
+			Content:        input.Content,
+			Tags:           input.Tags,
+			CodeSnippet:    input.CodeSnippet,
+			Description:    input.Description,
+			NextReviewDate: nextReviewDate,
+			Audio:          input.Audio,
+		}
+
+		v := validator.New()
+		resolveErr = app.resolveCardAudio(v, card)
+		if resolveErr != nil {
+			return resolveErr
+		}
+		if data.ValidateCard(v, card); !v.Valid() {
+			failures[strconv.Itoa(i)] = v.Errors
+			return nil
+		}
+		cards = append(cards, card)
+		indexes = append(indexes, i)
+		return nil
+	})
+	if resolveErr != nil {
+		app.serverErrorResponse(w, r, resolveErr)
+		return
+	}
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if count == 0 {
+		app.failedValidationResponse(w, r, map[string]string{"cards": "must contain at least 1 card"})
+		return
+	}
+
+	if len(cards) == 0 {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, failures)
+		return
+	}
+
+	err = app.models.Cards.InsertMany(cards)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	ids := make([]*int64, count)
+	for i, card := range cards {
+		ids[indexes[i]] = &card.ID
+	}
+	app.metrics.cardsCreated.Add(float64(len(cards)))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"ids": ids, "errors": failures}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readBatch reads the body one card at a time, passing each raw JSON value
+// and its index to fn without decoding it, so that a single malformed card
+// doesn't fail the whole batch. It returns the number of cards read, or the
+// first error from the body or from fn.
+func (app *application) readBatch(body io.Reader, ndjson bool, fn func(i int, raw json.RawMessage) error) (int, error) {
+	dec := json.NewDecoder(body)
+
+	if !ndjson {
+		token, err := dec.Token()
+		if err != nil {
+			return 0, app.batchDecodeError(err)
+		}
+		if delim, ok := token.(json.Delim); !ok || delim != '[' {
+			return 0, errors.New("body must contain a JSON array of cards")
+		}
+	}
+
+	count := 0
+	for {
+		if !ndjson && !dec.More() {
+			break
+		}
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if ndjson && errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, app.batchDecodeError(err)
+		}
+		if count == app.config.batch.maxCards {
+			return 0, fmt.Errorf("body must not contain more than %d cards", app.config.batch.maxCards)
+		}
+		err = fn(count, raw)
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+
+	if !ndjson {
+		if _, err := dec.Token(); err != nil {
+			return 0, app.batchDecodeError(err)
+		}
+		if dec.More() {
+			return 0, errors.New("body must only contain a single JSON array")
+		}
+	}
+	return count, nil
+}
+
+func (app *application) batchDecodeError(err error) error {
+	var syntaxError *json.SyntaxError
+	var maxBytesError *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &syntaxError):
+		return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("body contains badly-formed JSON")
+	case errors.Is(err, io.EOF):
+		return errors.New("body must not be empty")
+	case errors.As(err, &maxBytesError):
+		return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadBatch(t *testing.T) {
+	app := &application{}
+	app.config.batch.maxCards = 3
+
+	tests := []struct {
+		name    string
+		body    string
+		ndjson  bool
+		want    []string
+		wantErr string
+	}{
+		{name: "array", body: `[{"title":"a"}, {"title":"b"}]`, want: []string{`{"title":"a"}`, `{"title":"b"}`}},
+		{name: "empty array", body: `[]`, want: []string{}},
+		{name: "invalid card kept", body: `[{"title":"a"}, 42]`, want: []string{`{"title":"a"}`, `42`}},
+		{name: "not an array", body: `{"title":"a"}`, wantErr: "body must contain a JSON array of cards"},
+		{name: "empty body", body: ``, wantErr: "body must not be empty"},
+		{name: "unterminated array", body: `[{"title":"a"}`, wantErr: "body contains badly-formed JSON"},
+		{name: "trailing value", body: `[{"title":"a"}] []`, wantErr: "body must only contain a single JSON array"},
+		{name: "too many cards", body: `[1, 2, 3, 4]`, wantErr: "body must not contain more than 3 cards"},
+		{name: "ndjson", body: "{\"title\":\"a\"}\n{\"title\":\"b\"}\n", ndjson: true, want: []string{`{"title":"a"}`, `{"title":"b"}`}},
+		{name: "empty ndjson", body: ``, ndjson: true, want: []string{}},
+		{name: "malformed ndjson line", body: "{\"title\":\"a\"}\n{\"title\":\n", ndjson: true, wantErr: "body contains badly-formed JSON"},
+		{name: "too many ndjson cards", body: "1\n2\n3\n4\n", ndjson: true, wantErr: "body must not contain more than 3 cards"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			count, err := app.readBatch(strings.NewReader(tt.body), tt.ndjson, func(i int, raw json.RawMessage) error {
+				if i != len(got) {
+					t.Errorf("card %d passed with index %d", len(got), i)
+				}
+				got = append(got, string(raw))
+				return nil
+			})
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("readBatch error = %v; want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != len(tt.want) || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readBatch = %d, %q; want %q", count, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("stops at callback error", func(t *testing.T) {
+		stop := errors.New("stop")
+		calls := 0
+		_, err := app.readBatch(strings.NewReader(`[1, 2, 3]`), false, func(i int, raw json.RawMessage) error {
+			calls++
+			return stop
+		})
+		if !errors.Is(err, stop) || calls != 1 {
+			t.Errorf("readBatch = %v after %d calls; want the callback's error after 1", err, calls)
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+	"github.com/vynquoc/cs-flash-cards/internal/validator"
+)
+
+// cardActions maps the collection-level actions served under POST
+// /v1/cards/:id to their handlers. httprouter doesn't allow static segments
+// next to the :id wildcard that the revision restore route needs, so
+// /v1/cards/bulk and /v1/cards/batch are dispatched by cardActionHandler.
+func (app *application) cardActions() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"bulk":  app.bulkCardsHandler,
+		"batch": app.batchCreateCardsHandler,
+	}
+}
+
+func (app *application) cardActionHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	handler, ok := app.cardActions()[params.ByName("id")]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+	handler(w, r)
+}
+
+func (app *application) bulkCardsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Action         string           `json:"action"`
+		IDs            []int64          `json:"ids"`
+		Filter         *data.BulkFilter `json:"filter"`
+		Tags           []string         `json:"tags"`
+		NextReviewDate time.Time        `json:"next_review_date"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	op := &data.BulkOperation{
+		Action:         input.Action,
+		IDs:            input.IDs,
+		Filter:         input.Filter,
+		Tags:           input.Tags,
+		NextReviewDate: input.NextReviewDate,
+	}
+	if op.Action == data.BulkResetProgress {
+		op.NextReviewDate = app.calculateReviewDate(time.Now().Truncate(24*time.Hour), 1)
+	}
+
+	v := validator.New()
+	if data.ValidateBulkOperation(v, op); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	results, err := app.models.Cards.Bulk(op)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTooManyCards):
+			v.AddError("filter", fmt.Sprintf("must not match more than %d cards", data.MaxBulkCards))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"action": op.Action, "results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -18,6 +18,7 @@ func (app *application) createCardHandler(w http.ResponseWriter, r *http.Request
 		CodeSnippet    *data.CodeSnippet `json:"code_snippet"`
 		NextReviewDate time.Time         `json:"next_review_date"`
 		Description    string            `json:"description"`
+		Audio          *data.CardAudio   `json:"audio"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -30,6 +31,7 @@ func (app *application) createCardHandler(w http.ResponseWriter, r *http.Request
 		Content:     input.Content,
 		Tags:        input.Tags,
 		Description: input.Description,
+		Audio:       input.Audio,
 	}
 
 	card.NextReviewDate = app.calculateReviewDate(time.Now().Truncate(24*time.Hour), 1)
@@ -39,6 +41,11 @@ func (app *application) createCardHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	v := validator.New()
+	err = app.resolveCardAudio(v, card)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	if data.ValidateCard(v, card); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -49,8 +56,11 @@ func (app *application) createCardHandler(w http.ResponseWriter, r *http.Request
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.metrics.cardsCreated.Inc()
+
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/cards/%d", card.ID))
+	headers.Set("ETag", app.cardETag(card))
 
 	err = app.writeJSON(w, http.StatusCreated, envelope{"card": card}, headers)
 	if err != nil {
@@ -75,7 +85,10 @@ func (app *application) showCardHandler(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"card": card}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", app.cardETag(card))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"card": card}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -97,13 +110,19 @@ func (app *application) updateCardHandler(w http.ResponseWriter, r *http.Request
 		}
 		return
 	}
+	if !app.ifMatch(r, app.cardETag(card)) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
 	var input struct {
 		Title          *string           `json:"title"`
 		Tags           []string          `json:"tags"`
 		Content        *string           `json:"content"`
 		CodeSnippet    *data.CodeSnippet `json:"code_snippet"`
 		Description    *string           `json:"description"`
-		NextReviewDate time.Time         `json:"next_review_date"`
+		NextReviewDate *time.Time        `json:"next_review_date"`
+		Suspended      *bool             `json:"suspended"`
+		Audio          *data.CardAudio   `json:"audio"`
 	}
 	err = app.readJSON(w, r, &input)
 	if err != nil {
@@ -125,8 +144,23 @@ func (app *application) updateCardHandler(w http.ResponseWriter, r *http.Request
 	if input.Description != nil {
 		card.Description = *input.Description
 	}
-	card.NextReviewDate = input.NextReviewDate
+	if input.Suspended != nil {
+		card.Suspended = *input.Suspended
+	}
+	if input.Audio != nil {
+		card.Audio = audioUpdate(input.Audio)
+	}
+	reviewed := false
+	if input.NextReviewDate != nil {
+		reviewed = !input.NextReviewDate.Equal(card.NextReviewDate)
+		card.NextReviewDate = *input.NextReviewDate
+	}
 	v := validator.New()
+	err = app.resolveCardAudio(v, card)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	if data.ValidateCard(v, card); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -134,10 +168,22 @@ func (app *application) updateCardHandler(w http.ResponseWriter, r *http.Request
 
 	err = app.models.Cards.Update(card)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"card": card}, nil)
+	if reviewed {
+		app.recordReview(nil)
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", app.cardETag(card))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"card": card}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -149,7 +195,7 @@ func (app *application) deleteCardHandler(w http.ResponseWriter, r *http.Request
 		app.notFoundResponse(w, r)
 		return
 	}
-	err = app.models.Cards.Delete(id)
+	card, err := app.models.Cards.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -159,7 +205,21 @@ func (app *application) deleteCardHandler(w http.ResponseWriter, r *http.Request
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "card successfully deleted"}, nil)
+	if !app.ifMatch(r, app.cardETag(card)) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+	err = app.models.Cards.Delete(card)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "card successfully moved to trash"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -167,8 +227,9 @@ func (app *application) deleteCardHandler(w http.ResponseWriter, r *http.Request
 
 func (app *application) listCardsHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title string
-		Tags  []string
+		Title  string
+		Tags   []string
+		Fields []string
 		data.Filters
 	}
 
@@ -176,33 +237,44 @@ func (app *application) listCardsHandler(w http.ResponseWriter, r *http.Request)
 	qs := r.URL.Query()
 	input.Title = app.readString(qs, "title", "")
 	input.Tags = app.readCSV(qs, "tags", []string{})
+	input.Fields = app.readCSV(qs, "fields", nil)
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "created_at")
 	input.Filters.SortSafeList = []string{"id", "title", "created_at", "next_review_date", "-id", "-title", "-created_at", "-next_review_date"}
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.Count = app.readString(qs, "count", data.CountExact)
 
+	data.ValidateFields(v, input.Fields)
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	cards, metadata, err := app.models.Cards.GetAll(input.Title, input.Tags, input.Filters)
+	cards, metadata, err := app.models.Cards.GetAll(input.Title, input.Tags, input.Filters, input.Fields)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"cards": cards, "metadata": metadata}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"cards": app.projectCards(cards, input.Fields), "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) listReviewCardHandler(w http.ResponseWriter, r *http.Request) {
-	cards, err := app.models.Cards.GetReviewCards()
+	fields := app.readCSV(r.URL.Query(), "fields", nil)
+
+	v := validator.New()
+	if data.ValidateFields(v, fields); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	cards, err := app.models.Cards.GetReviewCards(fields)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"cards": cards}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"cards": app.projectCards(cards, fields)}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
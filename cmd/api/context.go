@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+type contextKey string
+
+const requestIDContextKey = contextKey("requestID")
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns the request's ID, or an empty string for
+// requests that didn't pass through the requestID middleware.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
@@ -6,11 +6,16 @@ import (
 )
 
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.Println(err)
+	app.logger.Error(err.Error(),
+		"request_id", app.contextGetRequestID(r),
+		"method", r.Method,
+		"url", r.URL.String(),
+		"remote_addr", clientIP(r),
+	)
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
-	env := envelope{"error": message}
+	env := envelope{"error": message, "request_id": app.contextGetRequestID(r)}
 	err := app.writeJSON(w, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
@@ -39,3 +44,48 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
+
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please try again"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since it was last fetched"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+func (app *application) idempotencyKeyMismatchResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the Idempotency-Key header was already used for a different request"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, message)
+}
+
+func (app *application) idempotencyKeyInProgressResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a request with this Idempotency-Key header is still being processed"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) syncTokenExpiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the sync token has expired, please sync again without a token"
+	app.errorResponse(w, r, http.StatusGone, message)
+}
+
+func (app *application) uploadTooLargeResponse(w http.ResponseWriter, r *http.Request, maxBytes int64) {
+	message := fmt.Sprintf("the uploaded file must not be larger than %d bytes", maxBytes)
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, message)
+}
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, allowed string) {
+	message := fmt.Sprintf("the uploaded file must be a valid %s file", allowed)
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+func (app *application) directUploadsUnsupportedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "direct uploads need the s3 storage backend, please upload through /v1/upload instead"
+	app.errorResponse(w, r, http.StatusNotImplemented, message)
+}
+
+func (app *application) uploadNotReceivedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the file has not been uploaded to the presigned URL yet"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
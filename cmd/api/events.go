@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+)
+
+const (
+	eventReplayBatch       = 500
+	eventSubscriberBuffer  = 64
+	eventHeartbeatInterval = 15 * time.Second
+	eventListenerPing      = 90 * time.Second
+	eventHeldBackPoll      = time.Second
+	eventPruneInterval     = time.Hour
+)
+
+// eventHub fans events out from the single database listener to every open
+// event stream.
+type eventHub struct {
+	mu          sync.Mutex
+	closed      bool
+	subscribers map[chan *data.Event]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[chan *data.Event]struct{})}
+}
+
+func (h *eventHub) subscribe() chan *data.Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan *data.Event, eventSubscriberBuffer)
+	if h.closed {
+		close(ch)
+		return ch
+	}
+	h.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan *data.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// broadcast sends the events to every subscriber. A subscriber that has
+// fallen too far behind is dropped; its client reconnects with Last-Event-ID
+// and catches up from the event log.
+func (h *eventHub) broadcast(events []*data.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+	send:
+		for _, event := range events {
+			select {
+			case ch <- event:
+			default:
+				delete(h.subscribers, ch)
+				close(ch)
+				break send
+			}
+		}
+	}
+}
+
+// closeAll ends every open stream. It is called when the server shuts down,
+// since streams would otherwise hold the shutdown open until it times out.
+func (h *eventHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for ch := range h.subscribers {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// startEventListener listens for notifications of new events and broadcasts
+// them to the open streams. Notifications only carry an event id, so after
+// each one, and after reconnecting, it reads everything it hasn't seen yet
+// from the event log. While events are held back behind an older running
+// transaction it also polls, as that transaction may end without notifying.
+func (app *application) startEventListener() error {
+	last, err := app.models.Events.Latest()
+	if err != nil {
+		return err
+	}
+
+	listener := pq.NewListener(app.config.db.dsn, 10*time.Second, time.Minute, func(_ pq.ListenerEventType, err error) {
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+	err = listener.Listen(data.EventsChannel)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	app.background(func() {
+		defer listener.Close()
+
+		ticker := time.NewTicker(eventListenerPing)
+		defer ticker.Stop()
+
+		var poll <-chan time.Time
+		catchUp := func() {
+			for {
+				events, err := app.models.Events.GetSince(last, eventReplayBatch)
+				if err != nil {
+					app.logger.Error(err.Error())
+					break
+				}
+				if len(events) > 0 {
+					app.events.broadcast(events)
+					last = events[len(events)-1].Position()
+				}
+				if len(events) < eventReplayBatch {
+					break
+				}
+			}
+
+			poll = nil
+			held, err := app.models.Events.HasHeldBack()
+			if err != nil || held {
+				if err != nil {
+					app.logger.Error(err.Error())
+				}
+				poll = time.After(eventHeldBackPoll)
+			}
+		}
+
+		for {
+			select {
+			case <-app.shutdown:
+				return
+			case <-listener.Notify:
+				catchUp()
+			case <-poll:
+				catchUp()
+			case <-ticker.C:
+				go listener.Ping()
+			}
+		}
+	})
+
+	return nil
+}
+
+func (app *application) startEventPruner() {
+	app.runPeriodically(eventPruneInterval, func() {
+		_, err := app.models.Events.DeleteBefore(time.Now().Add(-app.config.events.retention))
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+}
+
+// eventStreamHandler streams card and review events as Server-Sent Events.
+// Clients that send Last-Event-ID, or the last_event_id query string parameter,
+// are first sent every logged event after that one. If it has been pruned
+// from the log they are sent every event still in it.
+func (app *application) eventStreamHandler(w http.ResponseWriter, r *http.Request) {
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	var last data.LogPosition
+	resume := lastEventID != ""
+	if resume {
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || id < 0 {
+			app.badRequestResponse(w, r, fmt.Errorf("invalid Last-Event-ID %q", lastEventID))
+			return
+		}
+		last, err = app.models.Events.Position(id)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Streams are long-lived, so lift the server's write timeout.
+	rc := http.NewResponseController(w)
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Subscribe before replaying so that nothing committed in between is missed.
+	events := app.events.subscribe()
+	defer app.events.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	if resume {
+		for {
+			replay, err := app.models.Events.GetSince(last, eventReplayBatch)
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+			for _, event := range replay {
+				err = writeEvent(w, event)
+				if err != nil {
+					return
+				}
+				last = event.Position()
+			}
+			if len(replay) < eventReplayBatch {
+				break
+			}
+		}
+	}
+	err = rc.Flush()
+	if err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if !last.Before(event.Position()) {
+				continue
+			}
+			err = writeEvent(w, event)
+			if err != nil {
+				return
+			}
+			last = event.Position()
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+		}
+		err = rc.Flush()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event *data.Event) error {
+	js, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, js)
+	return err
+}
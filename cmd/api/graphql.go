@@ -0,0 +1,16 @@
+package main
+
+import (
+	"net/http"
+)
+
+// maxGraphQLBytes caps the body of a GraphQL request. Queries are small, so
+// this is the same limit as for JSON bodies elsewhere.
+const maxGraphQLBytes = 1_048_576
+
+// graphqlHandler serves the GraphQL API, which reads the same models as the
+// REST API. Errors are reported in the response body by the GraphQL server.
+func (app *application) graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxGraphQLBytes)
+	app.graphql.ServeHTTP(w, r)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	cardsv1 "github.com/vynquoc/cs-flash-cards/internal/proto/cards/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newGRPCServer returns the gRPC server for the services in internal/proto.
+// It is served on its own port, next to the JSON API.
+func (app *application) newGRPCServer() *grpc.Server {
+	srv := grpc.NewServer(grpc.UnaryInterceptor(app.grpcErrors))
+	cardsv1.RegisterCardServiceServer(srv, &cardService{app: app})
+	return srv
+}
+
+// stopGRPC lets in-flight calls finish until ctx is done, then cuts off the
+// rest.
+func (app *application) stopGRPC(ctx context.Context, srv *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		srv.Stop()
+		<-done
+	}
+}
+
+// grpcErrors turns any error that isn't already a status, and any panic,
+// into an INTERNAL status, logging the original as serverErrorResponse does.
+func (app *application) grpcErrors(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = app.grpcServerError(info.FullMethod, fmt.Errorf("%v", p))
+		}
+	}()
+
+	resp, err = handler(ctx, req)
+	if _, ok := status.FromError(err); !ok {
+		err = app.grpcServerError(info.FullMethod, err)
+	}
+	return resp, err
+}
+
+func (app *application) grpcServerError(method string, err error) error {
+	app.logger.Error(err.Error(), "method", method)
+	return status.Error(codes.Internal, "the server encountered a problem and could not process your request")
+}
+
+// The statuses below carry the same messages as the JSON API's responses,
+// and use the codes grpc-gateway maps to the same HTTP status where there is
+// one.
+
+func grpcNotFound() error {
+	return status.Error(codes.NotFound, "the requested resource could not be found")
+}
+
+func grpcEditConflict() error {
+	return status.Error(codes.Aborted, "unable to update the record due to an edit conflict, please try again")
+}
+
+func grpcPreconditionFailed() error {
+	return status.Error(codes.FailedPrecondition, "the resource has been modified since it was last fetched")
+}
+
+// grpcFailedValidation reports a validator's errors as a google.rpc.BadRequest
+// detail, with one field violation per field.
+func grpcFailedValidation(errors map[string]string) error {
+	fields := make([]string, 0, len(errors))
+	for field := range errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	details := &errdetails.BadRequest{}
+	for _, field := range fields {
+		details.FieldViolations = append(details.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: errors[field],
+		})
+	}
+
+	st, err := status.New(codes.InvalidArgument, "the request failed validation").WithDetails(details)
+	if err != nil {
+		return err
+	}
+	return st.Err()
+}
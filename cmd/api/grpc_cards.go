@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+	cardsv1 "github.com/vynquoc/cs-flash-cards/internal/proto/cards/v1"
+	"github.com/vynquoc/cs-flash-cards/internal/validator"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// cardService implements cards.v1.CardService over the same models and
+// validation as the card handlers in cards.go.
+type cardService struct {
+	cardsv1.UnimplementedCardServiceServer
+	app *application
+}
+
+func (s *cardService) CreateCard(ctx context.Context, req *cardsv1.CreateCardRequest) (*cardsv1.Card, error) {
+	card := &data.Card{
+		Title:       req.GetTitle(),
+		Content:     req.GetContent(),
+		Tags:        req.GetTags(),
+		Description: req.GetDescription(),
+		CodeSnippet: codeSnippetFromProto(req.GetCodeSnippet()),
+		Audio:       audioFromProto(req.GetAudio()),
+	}
+	card.NextReviewDate = s.app.calculateReviewDate(time.Now().Truncate(24*time.Hour), 1)
+
+	v := validator.New()
+	err := s.app.resolveCardAudio(v, card)
+	if err != nil {
+		return nil, err
+	}
+	if data.ValidateCard(v, card); !v.Valid() {
+		return nil, grpcFailedValidation(v.Errors)
+	}
+
+	err = s.app.models.Cards.Insert(card)
+	if err != nil {
+		return nil, err
+	}
+	s.app.metrics.cardsCreated.Inc()
+
+	return cardToProto(card)
+}
+
+func (s *cardService) GetCard(ctx context.Context, req *cardsv1.GetCardRequest) (*cardsv1.Card, error) {
+	card, err := s.app.models.Cards.Get(req.GetId())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, grpcNotFound()
+		default:
+			return nil, err
+		}
+	}
+	return cardToProto(card)
+}
+
+func (s *cardService) UpdateCard(ctx context.Context, req *cardsv1.UpdateCardRequest) (*cardsv1.Card, error) {
+	in := req.GetCard()
+	v := validator.New()
+	v.Check(in != nil, "card", "must be provided")
+	v.Check(len(req.GetUpdateMask().GetPaths()) > 0, "update_mask", "must be provided")
+	if !v.Valid() {
+		return nil, grpcFailedValidation(v.Errors)
+	}
+
+	card, err := s.app.models.Cards.Get(in.GetId())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, grpcNotFound()
+		default:
+			return nil, err
+		}
+	}
+	if req.GetVersion() != 0 && req.GetVersion() != card.Version {
+		return nil, grpcPreconditionFailed()
+	}
+
+	reviewed := false
+	for _, path := range req.GetUpdateMask().GetPaths() {
+		switch path {
+		case "title":
+			card.Title = in.GetTitle()
+		case "tags":
+			card.Tags = in.GetTags()
+		case "content":
+			card.Content = in.GetContent()
+		case "code_snippet":
+			card.CodeSnippet = codeSnippetFromProto(in.GetCodeSnippet())
+		case "description":
+			card.Description = in.GetDescription()
+		case "suspended":
+			card.Suspended = in.GetSuspended()
+		case "audio":
+			card.Audio = nil
+			if in.GetAudio() != nil {
+				card.Audio = audioUpdate(audioFromProto(in.GetAudio()))
+			}
+		case "next_review_date":
+			if in.GetNextReviewDate() == nil {
+				v.AddError("next_review_date", "must be provided")
+				continue
+			}
+			next := in.GetNextReviewDate().AsTime()
+			reviewed = !next.Equal(card.NextReviewDate)
+			card.NextReviewDate = next
+		default:
+			v.AddError("update_mask", fmt.Sprintf("%q is not a field that can be updated", path))
+		}
+	}
+
+	err = s.app.resolveCardAudio(v, card)
+	if err != nil {
+		return nil, err
+	}
+	if data.ValidateCard(v, card); !v.Valid() {
+		return nil, grpcFailedValidation(v.Errors)
+	}
+
+	err = s.app.models.Cards.Update(card)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return nil, grpcEditConflict()
+		default:
+			return nil, err
+		}
+	}
+	if reviewed {
+		s.app.recordReview(nil)
+	}
+
+	return cardToProto(card)
+}
+
+func (s *cardService) DeleteCard(ctx context.Context, req *cardsv1.DeleteCardRequest) (*emptypb.Empty, error) {
+	card, err := s.app.models.Cards.Get(req.GetId())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, grpcNotFound()
+		default:
+			return nil, err
+		}
+	}
+	if req.GetVersion() != 0 && req.GetVersion() != card.Version {
+		return nil, grpcPreconditionFailed()
+	}
+
+	err = s.app.models.Cards.Delete(card)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return nil, grpcEditConflict()
+		default:
+			return nil, err
+		}
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *cardService) ListCards(ctx context.Context, req *cardsv1.ListCardsRequest) (*cardsv1.ListCardsResponse, error) {
+	filters := data.Filters{
+		Page:         1,
+		PageSize:     int(req.GetPageSize()),
+		Sort:         req.GetSort(),
+		SortSafeList: []string{"id", "title", "created_at", "next_review_date", "-id", "-title", "-created_at", "-next_review_date"},
+		Cursor:       req.GetPageToken(),
+		Count:        data.CountNone,
+	}
+	if filters.PageSize == 0 {
+		filters.PageSize = 20
+	}
+	if filters.Sort == "" {
+		filters.Sort = "created_at"
+	}
+
+	// Errors about the cursor are reported against the field it is sent in.
+	fv := validator.New()
+	data.ValidateFilters(fv, filters)
+	v := validator.New()
+	for field, message := range fv.Errors {
+		if field == "cursor" {
+			field = "page_token"
+		}
+		v.AddError(field, message)
+	}
+	if !v.Valid() {
+		return nil, grpcFailedValidation(v.Errors)
+	}
+
+	tags := req.GetTags()
+	if tags == nil {
+		tags = []string{}
+	}
+	cards, metadata, err := s.app.models.Cards.GetAll(req.GetTitle(), tags, filters, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &cardsv1.ListCardsResponse{NextPageToken: metadata.NextCursor}
+	resp.Cards, err = cardsToProto(cards)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *cardService) ReviewCard(ctx context.Context, req *cardsv1.ReviewCardRequest) (*cardsv1.ReviewCardResponse, error) {
+	review := &data.Review{
+		ClientID:       req.GetClientId(),
+		CardID:         req.GetCardId(),
+		ReviewedAt:     timeFromProto(req.GetReviewedAt()),
+		NextReviewDate: timeFromProto(req.GetNextReviewDate()),
+	}
+	if req.Grade != nil {
+		grade := int(req.GetGrade())
+		review.Grade = &grade
+	}
+
+	result, err := s.app.mergeReview(review)
+	if err != nil {
+		return nil, err
+	}
+	switch result.Status {
+	case syncStatusInvalid:
+		return nil, grpcFailedValidation(result.Errors)
+	case syncStatusNotFound:
+		return nil, grpcNotFound()
+	}
+
+	card, err := s.app.models.Cards.Get(review.CardID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, grpcNotFound()
+		default:
+			return nil, err
+		}
+	}
+	pb, err := cardToProto(card)
+	if err != nil {
+		return nil, err
+	}
+	return &cardsv1.ReviewCardResponse{Card: pb, Duplicate: result.Status == syncStatusDuplicate}, nil
+}
+
+func (s *cardService) GetDueCards(ctx context.Context, req *cardsv1.GetDueCardsRequest) (*cardsv1.GetDueCardsResponse, error) {
+	cards, err := s.app.models.Cards.GetReviewCards(nil)
+	if err != nil {
+		return nil, err
+	}
+	pbs, err := cardsToProto(cards)
+	if err != nil {
+		return nil, err
+	}
+	return &cardsv1.GetDueCardsResponse{Cards: pbs}, nil
+}
+
+func cardToProto(card *data.Card) (*cardsv1.Card, error) {
+	pb := &cardsv1.Card{
+		Id:             card.ID,
+		CreatedAt:      timestamppb.New(card.CreatedAt),
+		Title:          card.Title,
+		Tags:           card.Tags,
+		Content:        card.Content,
+		NextReviewDate: timestamppb.New(card.NextReviewDate),
+		Description:    card.Description,
+		Version:        card.Version,
+		Suspended:      card.Suspended,
+	}
+	if card.CodeSnippet != nil {
+		snippet, err := structpb.NewStruct(*card.CodeSnippet)
+		if err != nil {
+			return nil, err
+		}
+		pb.CodeSnippet = snippet
+	}
+	if card.Audio != nil {
+		pb.Audio = &cardsv1.CardAudio{Url: card.Audio.URL, Side: card.Audio.Side, DurationMs: card.Audio.DurationMS}
+	}
+	return pb, nil
+}
+
+func cardsToProto(cards []*data.Card) ([]*cardsv1.Card, error) {
+	pbs := make([]*cardsv1.Card, len(cards))
+	for i, card := range cards {
+		pb, err := cardToProto(card)
+		if err != nil {
+			return nil, err
+		}
+		pbs[i] = pb
+	}
+	return pbs, nil
+}
+
+func codeSnippetFromProto(s *structpb.Struct) *data.CodeSnippet {
+	if s == nil {
+		return nil
+	}
+	snippet := data.CodeSnippet(s.AsMap())
+	return &snippet
+}
+
+// audioFromProto leaves out the duration, which is read from the uploaded
+// file rather than taken from the client.
+func audioFromProto(audio *cardsv1.CardAudio) *data.CardAudio {
+	if audio == nil {
+		return nil
+	}
+	return &data.CardAudio{URL: audio.GetUrl(), Side: audio.GetSide()}
+}
+
+// timeFromProto returns the zero time for a missing timestamp, which
+// validation reports as not provided, where AsTime would give the epoch.
+func timeFromProto(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
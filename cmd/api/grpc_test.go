@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	cardsv1 "github.com/vynquoc/cs-flash-cards/internal/proto/cards/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// TestCardServiceValidation covers calls that fail validation before any
+// model is used, so they run without a database.
+func TestCardServiceValidation(t *testing.T) {
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	srv := app.newGRPCServer()
+	ln := bufconn.Listen(1 << 20)
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := cardsv1.NewCardServiceClient(conn)
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		call       func() error
+		wantFields []string
+	}{
+		{
+			name: "update without a mask",
+			call: func() error {
+				_, err := client.UpdateCard(ctx, &cardsv1.UpdateCardRequest{Card: &cardsv1.Card{Id: 1}})
+				return err
+			},
+			wantFields: []string{"update_mask"},
+		},
+		{
+			name: "update without a card",
+			call: func() error {
+				_, err := client.UpdateCard(ctx, &cardsv1.UpdateCardRequest{UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}}})
+				return err
+			},
+			wantFields: []string{"card"},
+		},
+		{
+			name: "list with a large page",
+			call: func() error {
+				_, err := client.ListCards(ctx, &cardsv1.ListCardsRequest{PageSize: 1000})
+				return err
+			},
+			wantFields: []string{"page_size"},
+		},
+		{
+			name: "list with a bad sort and token",
+			call: func() error {
+				_, err := client.ListCards(ctx, &cardsv1.ListCardsRequest{Sort: "content", PageToken: "nope"})
+				return err
+			},
+			wantFields: []string{"page_token", "sort"},
+		},
+		{
+			name: "empty review",
+			call: func() error {
+				_, err := client.ReviewCard(ctx, &cardsv1.ReviewCardRequest{})
+				return err
+			},
+			wantFields: []string{"card_id", "client_id", "next_review_date", "reviewed_at"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := status.Convert(tt.call())
+			if st.Code() != codes.InvalidArgument {
+				t.Fatalf("code = %s (%s); want %s", st.Code(), st.Message(), codes.InvalidArgument)
+			}
+
+			var fields []string
+			for _, detail := range st.Details() {
+				if br, ok := detail.(*errdetails.BadRequest); ok {
+					for _, violation := range br.GetFieldViolations() {
+						fields = append(fields, violation.GetField())
+					}
+				}
+			}
+			if len(fields) != len(tt.wantFields) {
+				t.Fatalf("field violations = %v; want %v", fields, tt.wantFields)
+			}
+			for i := range fields {
+				if fields[i] != tt.wantFields[i] {
+					t.Errorf("field violations = %v; want %v", fields, tt.wantFields)
+				}
+			}
+		})
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	info := &grpc.UnaryServerInfo{FullMethod: "/cards.v1.CardService/GetCard"}
+
+	tests := []struct {
+		name    string
+		handler grpc.UnaryHandler
+		want    codes.Code
+	}{
+		{"ok", func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }, codes.OK},
+		{"status", func(ctx context.Context, req interface{}) (interface{}, error) { return nil, grpcNotFound() }, codes.NotFound},
+		{"plain error", func(ctx context.Context, req interface{}) (interface{}, error) { return nil, errors.New("db down") }, codes.Internal},
+		{"panic", func(ctx context.Context, req interface{}) (interface{}, error) { panic("boom") }, codes.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := app.grpcErrors(context.Background(), nil, info, tt.handler)
+			st := status.Convert(err)
+			if st.Code() != tt.want {
+				t.Errorf("code = %s; want %s", st.Code(), tt.want)
+			}
+			if tt.want == codes.Internal && st.Message() != "the server encountered a problem and could not process your request" {
+				t.Errorf("message = %q; want the generic server error", st.Message())
+			}
+		})
+	}
+}
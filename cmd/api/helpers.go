@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/vynquoc/cs-flash-cards/internal/data"
 	"github.com/vynquoc/cs-flash-cards/internal/validator"
 )
 
@@ -28,6 +29,50 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+func (app *application) readRevisionParam(r *http.Request) (int32, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	rev, err := strconv.ParseInt(params.ByName("rev"), 10, 32)
+	if err != nil || rev < 1 {
+		return 0, errors.New("invalid rev parameter")
+	}
+
+	return int32(rev), nil
+}
+
+func (app *application) cardETag(card *data.Card) string {
+	return fmt.Sprintf(`"%d"`, card.Version)
+}
+
+// ifMatch reports whether the request's If-Match header, if any, matches the
+// given entity tag.
+func (app *application) ifMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// projectCards trims each card down to the requested fields. With no fields
+// requested the cards are returned unchanged.
+func (app *application) projectCards(cards []*data.Card, fields []string) interface{} {
+	if len(fields) == 0 {
+		return cards
+	}
+	projections := make([]map[string]interface{}, len(cards))
+	for i, card := range cards {
+		projections[i] = card.Project(fields)
+	}
+	return projections
+}
+
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
 	js, err := json.MarshalIndent(data, "", "\t")
 	if err != nil {
@@ -134,3 +179,25 @@ func (app *application) calculateReviewDate(old time.Time, days int) time.Time {
 	newDate := old.Add(time.Duration(days) * 24 * time.Hour)
 	return newDate
 }
+
+// background runs fn on a goroutine that the server waits for before exiting,
+// recovering any panic so that it can't take the server down with it.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+		app.runRecovered(fn)
+	}()
+}
+
+// runRecovered calls fn and logs, rather than propagates, any panic in it.
+func (app *application) runRecovered(fn func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.Error(fmt.Sprintf("%v", err))
+		}
+	}()
+
+	fn()
+}
@@ -5,103 +5,247 @@ import (
 	"database/sql"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	"github.com/vynquoc/cs-flash-cards/internal/data"
+	"github.com/vynquoc/cs-flash-cards/internal/graph"
+	"github.com/vynquoc/cs-flash-cards/internal/storage"
 )
 
 const version = "1.0.0"
 
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port            int
+	grpcPort        int
+	env             string
+	logLevel        string
+	shutdownTimeout time.Duration
+	db              struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
 	}
+	storage struct {
+		backend  string
+		localDir string
+		localURL string
+	}
 	s3 struct {
 		region     string
 		bucketName string
+		endpoint   string
+		pathStyle  bool
+		publicURL  string
+		acl        string
+	}
+	trash struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
+	batch struct {
+		maxCards int
+	}
+	idempotency struct {
+		ttl time.Duration
+	}
+	events struct {
+		retention time.Duration
+	}
+	upload struct {
+		maxBytes        int64
+		presignMaxBytes int64
+		presignExpiry   time.Duration
+	}
+	attachments struct {
+		retention  time.Duration
+		gcInterval time.Duration
+	}
+	graphql struct {
+		maxDepth      int
+		maxComplexity int
 	}
 }
 
 type application struct {
-	config config
-	logger *log.Logger
-	models data.Models
-	s3     *s3.S3
+	config   config
+	logger   *slog.Logger
+	models   data.Models
+	metrics  *appMetrics
+	blobs    storage.BlobStore
+	events   *eventHub
+	graphql  http.Handler
+	shutdown chan struct{}
+	wg       sync.WaitGroup
 }
 
 func main() {
+	// The level is set once the flags are parsed, but the logger is needed
+	// before then to report errors.
+	var logLevel slog.LevelVar
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: &logLevel}))
+
+	err := run(logger, &logLevel)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// run starts the server and returns once it has shut down. Errors are
+// returned rather than exiting, so that deferred cleanup such as closing the
+// connection pool always runs.
+func run(logger *slog.Logger, logLevel *slog.LevelVar) error {
 	err := godotenv.Load()
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		return fmt.Errorf("error loading .env file: %w", err)
 	}
 
 	var cfg config
 	flag.StringVar(&cfg.env, "env", "development", "Environment")
+	flag.IntVar(&cfg.grpcPort, "grpc-port", 4001, "Port of the gRPC server (0 to disable)")
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 30*time.Second, "How long in-flight requests get to finish on shutdown")
+	flag.StringVar(&cfg.logLevel, "log-level", "info", "Minimum level of log entries (debug|info|warn|error)")
 	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("DB_DSN"), "PostgreSQL DSN")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-mx-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.IntVar(&cfg.batch.maxCards, "batch-max-cards", 5000, "Maximum number of cards in a batch create request")
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "How long idempotency keys are remembered")
+	flag.StringVar(&cfg.storage.backend, "storage", envOrDefault("STORAGE_BACKEND", "local"), "Upload storage backend (local|s3)")
+	flag.StringVar(&cfg.storage.localDir, "storage-dir", "./media", "Directory for the local storage backend")
+	flag.StringVar(&cfg.storage.localURL, "storage-url", "", "Public base URL of the local storage backend (default http://localhost:<port>/v1/media)")
+	flag.StringVar(&cfg.s3.endpoint, "s3-endpoint", os.Getenv("S3_ENDPOINT"), "Endpoint of an S3-compatible service such as MinIO")
+	flag.BoolVar(&cfg.s3.pathStyle, "s3-path-style", false, "Use path-style S3 addressing")
+	flag.StringVar(&cfg.s3.publicURL, "s3-public-url", os.Getenv("S3_PUBLIC_URL"), "Base URL uploads are linked from, if not the bucket's own")
+	flag.StringVar(&cfg.s3.acl, "s3-acl", "public-read", "Canned ACL for uploaded objects (empty for none)")
+	flag.Int64Var(&cfg.upload.maxBytes, "upload-max-bytes", 10<<20, "Maximum size of an uploaded file in bytes")
+	flag.Int64Var(&cfg.upload.presignMaxBytes, "upload-presign-max-bytes", 100<<20, "Maximum size of a file uploaded directly to S3 in bytes")
+	flag.DurationVar(&cfg.upload.presignExpiry, "upload-presign-expiry", 15*time.Minute, "How long presigned upload URLs are valid")
+	flag.DurationVar(&cfg.attachments.retention, "attachment-retention", 7*24*time.Hour, "How long uploads no card links to are kept")
+	flag.DurationVar(&cfg.attachments.gcInterval, "attachment-gc-interval", time.Hour, "How often unused uploads are deleted")
+	flag.DurationVar(&cfg.events.retention, "events-retention", 7*24*time.Hour, "How long card events are kept for Last-Event-ID resumes")
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted cards stay in the trash")
+	flag.IntVar(&cfg.graphql.maxDepth, "graphql-max-depth", 10, "Maximum depth of a GraphQL operation")
+	flag.IntVar(&cfg.graphql.maxComplexity, "graphql-max-complexity", 2000, "Maximum complexity of a GraphQL operation")
+	flag.DurationVar(&cfg.trash.purgeInterval, "trash-purge-interval", time.Hour, "How often expired cards are purged from the trash")
 
 	flag.Parse()
 
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	err = logLevel.UnmarshalText([]byte(cfg.logLevel))
+	if err != nil {
+		return err
+	}
+
+	err = validateConfig(cfg)
+	if err != nil {
+		return err
+	}
+
 	cfg.s3.region = os.Getenv("AWS_REGION")
 	cfg.s3.bucketName = os.Getenv("AWS_BUCKET")
 	port, err := strconv.Atoi(os.Getenv("PORT"))
-
 	if err != nil {
-		logger.Fatal(err)
+		return err
 	}
 	cfg.port = port
 
 	db, err := openDB(cfg)
-
 	if err != nil {
-		logger.Fatal(err)
+		return err
 	}
 
 	defer db.Close()
 
-	logger.Printf("database connection pool established")
+	logger.Info("database connection pool established")
 
-	s3Session, err := createS3session(cfg)
+	blobs, err := openBlobStore(cfg)
 	if err != nil {
-		logger.Fatal(err)
+		return err
 	}
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		s3:     s3Session,
+		config:   cfg,
+		logger:   logger,
+		models:   data.NewModels(db),
+		blobs:    blobs,
+		events:   newEventHub(),
+		shutdown: make(chan struct{}),
+	}
+	app.metrics = app.newMetrics(db)
+	app.graphql = graph.NewHandler(app.models, logger, graph.Limits{
+		MaxDepth:      cfg.graphql.maxDepth,
+		MaxComplexity: cfg.graphql.maxComplexity,
+	})
+
+	// The listener is started first, as it is the only one that can fail, so
+	// nothing else is running if it does.
+	err = app.startEventListener()
+	if err != nil {
+		return err
 	}
 
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 & time.Second,
-		WriteTimeout: 30 * time.Second,
+	app.startTrashPurger()
+	app.startIdempotencyKeyPurger()
+	app.startAttachmentCollector()
+	app.startUploadPurger()
+	app.startWebhookDispatcher()
+	app.startEventPruner()
+
+	return app.serve()
+}
+
+// validateConfig rejects durations the server can't run with: intervals must
+// be positive, as tickers panic otherwise, and a negative retention would
+// delete everything at once.
+func validateConfig(cfg config) error {
+	positive := []struct {
+		flag  string
+		value time.Duration
+	}{
+		{"attachment-gc-interval", cfg.attachments.gcInterval},
+		{"trash-purge-interval", cfg.trash.purgeInterval},
+		{"upload-presign-expiry", cfg.upload.presignExpiry},
+	}
+	for _, d := range positive {
+		if d.value <= 0 {
+			return fmt.Errorf("-%s must be greater than zero", d.flag)
+		}
 	}
 
-	logger.Printf("starting %s server on %s", cfg.env, srv.Addr)
-	err = srv.ListenAndServe()
-	logger.Fatal(err)
+	nonNegative := []struct {
+		flag  string
+		value time.Duration
+	}{
+		{"shutdown-timeout", cfg.shutdownTimeout},
+		{"idempotency-ttl", cfg.idempotency.ttl},
+		{"attachment-retention", cfg.attachments.retention},
+		{"events-retention", cfg.events.retention},
+		{"trash-retention", cfg.trash.retention},
+	}
+	for _, d := range nonNegative {
+		if d.value < 0 {
+			return fmt.Errorf("-%s must not be negative", d.flag)
+		}
+	}
 
+	if cfg.grpcPort < 0 || cfg.grpcPort > 65535 {
+		return fmt.Errorf("-grpc-port must be between 0 and 65535")
+	}
+	if cfg.graphql.maxDepth < 1 {
+		return fmt.Errorf("-graphql-max-depth must be greater than zero")
+	}
+	if cfg.graphql.maxComplexity < 1 {
+		return fmt.Errorf("-graphql-max-complexity must be greater than zero")
+	}
+	return nil
 }
 
 func openDB(cfg config) (*sql.DB, error) {
@@ -127,12 +271,31 @@ func openDB(cfg config) (*sql.DB, error) {
 	return db, nil
 }
 
-func createS3session(cfg config) (*s3.S3, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(cfg.s3.region),
-	})
-	if err != nil {
-		return nil, err
+func openBlobStore(cfg config) (storage.BlobStore, error) {
+	switch cfg.storage.backend {
+	case "local":
+		baseURL := cfg.storage.localURL
+		if baseURL == "" {
+			baseURL = fmt.Sprintf("http://localhost:%d/v1/media", cfg.port)
+		}
+		return storage.NewLocalStore(cfg.storage.localDir, baseURL)
+	case "s3":
+		return storage.NewS3Store(storage.S3Config{
+			Region:    cfg.s3.region,
+			Bucket:    cfg.s3.bucketName,
+			Endpoint:  cfg.s3.endpoint,
+			PathStyle: cfg.s3.pathStyle,
+			PublicURL: cfg.s3.publicURL,
+			ACL:       cfg.s3.acl,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.storage.backend)
+	}
+}
+
+func envOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
 	}
-	return s3.New(sess), nil
+	return defaultValue
 }
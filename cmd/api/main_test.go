@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateConfig(t *testing.T) {
+	valid := func() config {
+		var cfg config
+		cfg.shutdownTimeout = 30 * time.Second
+		cfg.idempotency.ttl = 24 * time.Hour
+		cfg.upload.presignExpiry = 15 * time.Minute
+		cfg.attachments.retention = 7 * 24 * time.Hour
+		cfg.attachments.gcInterval = time.Hour
+		cfg.events.retention = 7 * 24 * time.Hour
+		cfg.trash.retention = 30 * 24 * time.Hour
+		cfg.trash.purgeInterval = time.Hour
+		cfg.graphql.maxDepth = 10
+		cfg.graphql.maxComplexity = 2000
+		return cfg
+	}
+
+	tests := []struct {
+		name    string
+		change  func(cfg *config)
+		wantErr bool
+	}{
+		{"defaults", func(cfg *config) {}, false},
+		{"zero interval", func(cfg *config) { cfg.trash.purgeInterval = 0 }, true},
+		{"negative interval", func(cfg *config) { cfg.attachments.gcInterval = -time.Minute }, true},
+		{"zero presign expiry", func(cfg *config) { cfg.upload.presignExpiry = 0 }, true},
+		{"zero retention", func(cfg *config) { cfg.trash.retention = 0 }, false},
+		{"negative retention", func(cfg *config) { cfg.events.retention = -time.Hour }, true},
+		{"zero shutdown timeout", func(cfg *config) { cfg.shutdownTimeout = 0 }, false},
+		{"grpc disabled", func(cfg *config) { cfg.grpcPort = 0 }, false},
+		{"invalid grpc port", func(cfg *config) { cfg.grpcPort = 70000 }, true},
+		{"zero graphql depth", func(cfg *config) { cfg.graphql.maxDepth = 0 }, true},
+		{"zero graphql complexity", func(cfg *config) { cfg.graphql.maxComplexity = 0 }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.change(&cfg)
+			err := validateConfig(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateConfig() = %v; want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
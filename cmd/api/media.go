@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/vynquoc/cs-flash-cards/internal/storage"
+)
+
+// showMediaHandler serves a stored blob. It is how uploads are reached when
+// the local storage backend is in use; other backends link to their own URLs.
+func (app *application) showMediaHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	key := params.ByName("path")
+	if len(key) > 0 && key[0] == '/' {
+		key = key[1:]
+	}
+	if !storage.ValidKey(key) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	body, info, err := app.blobs.Get(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrInvalidKey):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer body.Close()
+
+	// Stored blobs never change under the same key, and SVGs are served
+	// sandboxed so that any script in them can't run in our origin.
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+
+	if rs, ok := body.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", info.ModTime, rs)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		io.Copy(w, body)
+	}
+}
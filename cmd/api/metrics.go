@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vynquoc/cs-flash-cards/internal/metrics"
+)
+
+// unmatchedRoute labels requests that didn't match any route, so that
+// scanners probing random paths can't create unbounded label values.
+const unmatchedRoute = "unmatched"
+
+type appMetrics struct {
+	registry        *metrics.Registry
+	requests        *metrics.CounterVec
+	requestDuration *metrics.HistogramVec
+	inFlight        *metrics.Gauge
+	cardsCreated    *metrics.Counter
+	reviews         *metrics.CounterVec
+	uploadFailures  *metrics.CounterVec
+}
+
+// newMetrics registers the HTTP, database pool and domain metrics exposed on
+// /metrics.
+func (app *application) newMetrics(db *sql.DB) *appMetrics {
+	reg := metrics.NewRegistry()
+	m := &appMetrics{
+		registry: reg,
+		requests: reg.Counter("http_requests_total",
+			"HTTP requests served, by route pattern and status class.", "method", "route", "status"),
+		requestDuration: reg.Histogram("http_request_duration_seconds",
+			"Time taken to serve HTTP requests, by route pattern.", metrics.DefaultBuckets, "method", "route"),
+		inFlight: reg.Gauge("http_requests_in_flight",
+			"HTTP requests currently being served.").With(),
+		cardsCreated: reg.Counter("cards_created_total",
+			"Cards created through the API.").With(),
+		reviews: reg.Counter("reviews_total",
+			"Card reviews recorded, by grade; none for reviews that only reschedule a card.", "grade"),
+		uploadFailures: reg.Counter("storage_upload_failures_total",
+			"Files that could not be written to the storage backend.", "backend"),
+	}
+
+	reg.GaugeFunc("cards_due", "Cards due for review.", func() float64 {
+		due, err := app.models.Cards.CountDue()
+		if err != nil {
+			app.logger.Error(err.Error())
+			return math.NaN()
+		}
+		return float64(due)
+	})
+
+	dbGauges := []struct {
+		name, help string
+		fn         func(s sql.DBStats) float64
+	}{
+		{"db_max_open_connections", "Maximum number of open database connections.", func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"db_open_connections", "Open database connections, in use or idle.", func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"db_in_use_connections", "Database connections currently in use.", func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"db_idle_connections", "Idle database connections.", func(s sql.DBStats) float64 { return float64(s.Idle) }},
+	}
+	for _, g := range dbGauges {
+		fn := g.fn
+		reg.GaugeFunc(g.name, g.help, func() float64 { return fn(db.Stats()) })
+	}
+	dbCounters := []struct {
+		name, help string
+		fn         func(s sql.DBStats) float64
+	}{
+		{"db_wait_count_total", "Times a query waited for a free database connection.", func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"db_wait_duration_seconds_total", "Time spent waiting for a free database connection.", func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+		{"db_max_idle_closed_total", "Connections closed because of the idle connection limit.", func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }},
+		{"db_max_idle_time_closed_total", "Connections closed for being idle too long.", func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }},
+		{"db_max_lifetime_closed_total", "Connections closed for reaching their maximum lifetime.", func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }},
+	}
+	for _, c := range dbCounters {
+		fn := c.fn
+		reg.CounterFunc(c.name, c.help, func() float64 { return fn(db.Stats()) })
+	}
+
+	return m
+}
+
+// instrument records request metrics for a handler under its route pattern,
+// such as /v1/cards/:id, rather than the raw path.
+func (app *application) instrument(route string, next http.Handler) http.Handler {
+	return app.instrumentFunc(func(*http.Request) string { return route }, next)
+}
+
+// instrumentFunc is instrument for routes whose label depends on the request,
+// such as those dispatching actions. The label is worked out before next
+// runs, so that responses replayed by the idempotency middleware are
+// labelled like the ones they replay.
+func (app *application) instrumentFunc(routeFor func(r *http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeFor(r)
+		start := time.Now()
+		app.metrics.inFlight.Inc()
+		defer app.metrics.inFlight.Dec()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		class := fmt.Sprintf("%dxx", rec.status/100)
+		app.metrics.requests.With(r.Method, route, class).Inc()
+		app.metrics.requestDuration.With(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// recordReview counts a review under its grade.
+func (app *application) recordReview(grade *int) {
+	label := "none"
+	if grade != nil {
+		label = strconv.Itoa(*grade)
+	}
+	app.metrics.reviews.With(label).Inc()
+}
+
+func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	err := app.metrics.registry.Write(w)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vynquoc/cs-flash-cards/internal/metrics"
+)
+
+// TestInstrumentActionRoutes checks that requests dispatched by action are
+// counted under the action's path rather than the :id pattern. The requests
+// fail before any model is used, so they run without a database.
+func TestInstrumentActionRoutes(t *testing.T) {
+	reg := metrics.NewRegistry()
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		metrics: &appMetrics{
+			registry:        reg,
+			requests:        reg.Counter("http_requests_total", "", "method", "route", "status"),
+			requestDuration: reg.Histogram("http_request_duration_seconds", "", metrics.DefaultBuckets, "method", "route"),
+			inFlight:        reg.Gauge("http_requests_in_flight", "").With(),
+		},
+	}
+	routes := app.routes()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/v1/cards/bulk", `route="/v1/cards/bulk"`},
+		{"/v1/cards/batch", `route="/v1/cards/batch"`},
+		{"/v1/uploads/presign", `route="/v1/uploads/presign"`},
+		{"/v1/cards/nope", `route="unmatched"`},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader("{"))
+		r.Header.Set("Content-Type", "application/json")
+		routes.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	var out bytes.Buffer
+	err := reg.Write(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if !strings.Contains(out.String(), "http_requests_total{method=\"POST\","+tt.want) {
+			t.Errorf("no request counted for %s with %s in:\n%s", tt.path, tt.want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), `route="/v1/cards/:id"`) || strings.Contains(out.String(), `route="/v1/uploads/:id"`) {
+		t.Errorf("requests counted under an action's :id pattern:\n%s", out.String())
+	}
+}
@@ -1,12 +1,275 @@
 package main
 
-import "net/http"
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+)
 
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, Idempotency-Key, X-Request-ID")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Location, Idempotent-Replayed, X-Request-ID")
 		next.ServeHTTP(w, r)
 	})
 }
+
+// requestIDRX limits the X-Request-ID values that are honored to ones that
+// are safe to echo back and log.
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestID tags each request with the X-Request-ID header sent by the client
+// or a proxy in front of the API, or with a generated ID if there is none, and
+// returns it in the response's X-Request-ID header.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, app.contextSetRequestID(r, id))
+	})
+}
+
+// responseRecorder records the status and size of a response for the access
+// log. Unwrap lets http.ResponseController reach the underlying writer, which
+// the event stream needs to flush.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// logRequest writes an access log entry once each request has been served.
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		app.logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("request_id", app.contextGetRequestID(r)),
+			slog.String("method", r.Method),
+			slog.String("url", r.URL.String()),
+			slog.String("remote_addr", clientIP(r)),
+			slog.Int("status", rec.status),
+			slog.Int64("bytes", rec.bytes),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		)
+	})
+}
+
+// maxIdempotentBodyBytes caps how much of a request body is spooled to disk
+// while its idempotency hash is computed.
+const maxIdempotentBodyBytes = 64 << 20
+
+// idempotencyLease is how long an idempotency key stays locked to a request
+// without being renewed. Renewals happen every third of it.
+const idempotencyLease = 30 * time.Second
+
+// renewIdempotencyLock extends the lock every third of a lease until the
+// returned function is called. The function waits for any renewal in flight,
+// so the lock is not in use once it returns.
+func (app *application) renewIdempotencyLock(r *http.Request, lock *data.IdempotencyLock) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(idempotencyLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := app.models.IdempotencyKeys.Extend(lock, idempotencyLease)
+				if err != nil {
+					app.logError(r, err)
+					if errors.Is(err, data.ErrIdempotencyLockLost) {
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+// idempotencyRecorder passes the response through while keeping a copy so it
+// can be stored against the request's idempotency key.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotency makes POST requests carrying an Idempotency-Key header safe to
+// retry: the first response is stored and replayed for later requests with the
+// same key and body, while reusing a key with a different body is rejected.
+func (app *application) idempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > 255 {
+			app.badRequestResponse(w, r, errors.New("the Idempotency-Key header must not be more than 255 characters long"))
+			return
+		}
+
+		// Spool the body to disk while hashing it, so large uploads aren't held
+		// in memory, then hand the spooled copy to the handler.
+		spool, err := os.CreateTemp("", "idempotency-*")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.Path)
+		body := http.MaxBytesReader(w, r.Body, maxIdempotentBodyBytes)
+		_, err = io.Copy(io.MultiWriter(spool, hash), body)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		_, err = spool.Seek(0, io.SeekStart)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		r.Body = spool
+
+		lock, stored, err := app.models.IdempotencyKeys.Reserve(key, hash.Sum(nil), app.config.idempotency.ttl, idempotencyLease)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrIdempotencyKeyMismatch):
+				app.idempotencyKeyMismatchResponse(w, r)
+			case errors.Is(err, data.ErrIdempotencyKeyInProgress):
+				app.idempotencyKeyInProgressResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if stored != nil {
+			for name, values := range stored.Headers {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		// Keep the lock while the request runs. If the server dies it lapses
+		// within a lease and a retry can take the key over.
+		stopRenewing := app.renewIdempotencyLock(r, lock)
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		completed := false
+		defer func() {
+			if !completed {
+				stopRenewing()
+				err := app.models.IdempotencyKeys.Release(lock)
+				if err != nil {
+					app.logError(r, err)
+				}
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+
+		// Server errors aren't stored so that the client can retry them.
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			return
+		}
+		headers := make(http.Header)
+		for _, name := range []string{"Content-Type", "Location", "ETag"} {
+			if value := w.Header().Get(name); value != "" {
+				headers.Set(name, value)
+			}
+		}
+		stopRenewing()
+		err = app.models.IdempotencyKeys.Complete(lock, &data.StoredResponse{
+			Status:  rec.status,
+			Headers: headers,
+			Body:    rec.body.Bytes(),
+		})
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+		completed = true
+	})
+}
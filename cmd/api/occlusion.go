@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+	"github.com/vynquoc/cs-flash-cards/internal/validator"
+)
+
+// createOcclusionCardsHandler creates one image occlusion card per mask over
+// an uploaded image. Each card hides its own mask on the question side and
+// reveals it on the answer side.
+func (app *application) createOcclusionCardsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title       string               `json:"title"`
+		Tags        []string             `json:"tags"`
+		Description string               `json:"description"`
+		ImageURL    string               `json:"image_url"`
+		Mode        string               `json:"mode"`
+		Masks       []data.OcclusionMask `json:"masks"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Mode == "" {
+		input.Mode = data.OcclusionHideAll
+	}
+
+	v := validator.New()
+	v.Check(input.ImageURL != "", "image_url", "must be provided")
+	data.ValidateOcclusionMasks(v, input.Mode, input.Masks)
+
+	imageURL := input.ImageURL
+	if input.ImageURL != "" {
+		attachment, err := app.attachmentForURL(input.ImageURL)
+		switch {
+		case err == nil && strings.HasPrefix(attachment.ContentType, "image/"):
+			imageURL = app.blobs.URL(attachment.Key)
+		case err == nil, errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("image_url", "must be the URL of an uploaded image")
+		default:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	nextReviewDate := app.calculateReviewDate(time.Now().Truncate(24*time.Hour), 1)
+
+	cards := make([]*data.Card, len(input.Masks))
+	for i, mask := range input.Masks {
+		content := mask.Label
+		if content == "" {
+			content = fmt.Sprintf("Region %d", i+1)
+		}
+		cards[i] = &data.Card{
+			Title:          fmt.Sprintf("%s (%d/%d)", input.Title, i+1, len(input.Masks)),
+			Content:        content,
+			Tags:           input.Tags,
+			Description:    input.Description,
+			NextReviewDate: nextReviewDate,
+			Occlusion:      data.NewCardOcclusion(imageURL, input.Mode, input.Masks, i),
+		}
+	}
+
+	// The cards differ only in generated fields, so validating one is enough.
+	v.Check(input.Title != "", "title", "must be provided")
+	if data.ValidateCard(v, cards[0]); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Cards.InsertMany(cards)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.metrics.cardsCreated.Add(float64(len(cards)))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"image_url": imageURL, "cards": cards}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
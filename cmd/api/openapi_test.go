@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// openAPIPath converts an httprouter path such as /v1/cards/:id into its
+// OpenAPI form, /v1/cards/{id}.
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// TestOpenAPISpec checks that the embedded spec describes exactly the routes
+// in routeTable, so a new or removed route can't ship without the spec being
+// updated.
+func TestOpenAPISpec(t *testing.T) {
+	app := &application{}
+
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	err := json.Unmarshal(openAPISpec, &spec)
+	if err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+
+	documented := map[string]bool{}
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			if method == "parameters" {
+				continue
+			}
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	// POST routes ending in :id that dispatch static actions are documented
+	// as one path per action.
+	actions := app.routeActions()
+
+	registered := map[string]bool{}
+	for _, rt := range app.routeTable() {
+		paths := []string{rt.path}
+		if rt.method == http.MethodPost && actions[rt.path] != nil {
+			paths = nil
+			for action := range actions[rt.path] {
+				paths = append(paths, strings.TrimSuffix(rt.path, ":id")+action)
+			}
+		}
+		for _, path := range paths {
+			registered[rt.method+" "+openAPIPath(path)] = true
+		}
+	}
+
+	var problems []string
+	for operation := range registered {
+		if !documented[operation] {
+			problems = append(problems, "missing from spec: "+operation)
+		}
+	}
+	for operation := range documented {
+		if !registered[operation] {
+			problems = append(problems, "not a registered route: "+operation)
+		}
+	}
+	sort.Strings(problems)
+	for _, problem := range problems {
+		t.Error(problem)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+	"github.com/vynquoc/cs-flash-cards/internal/media"
+	"github.com/vynquoc/cs-flash-cards/internal/storage"
+	"github.com/vynquoc/cs-flash-cards/internal/validator"
+)
+
+// expiredUploadGracePeriod is how long after its URL expires an upload can
+// still be completed, before the purger deletes it.
+const expiredUploadGracePeriod = 24 * time.Hour
+
+// presignableTypes are the file types clients may upload directly. SVGs are
+// left out because they must go through SanitizeSVG in /v1/upload.
+var presignableTypes = []string{media.TypePNG, media.TypeJPEG, media.TypeGIF, media.TypeWebP}
+
+// uploadActions maps the actions served under POST /v1/uploads/:id to their
+// handlers, for the same reason as cardActions.
+func (app *application) uploadActions() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"presign": app.presignUploadHandler,
+	}
+}
+
+func (app *application) uploadActionHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	handler, ok := app.uploadActions()[params.ByName("id")]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+	handler(w, r)
+}
+
+func (app *application) presignUploadHandler(w http.ResponseWriter, r *http.Request) {
+	presigner, ok := app.blobs.(storage.Presigner)
+	if !ok {
+		app.directUploadsUnsupportedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ContentType string `json:"content_type"`
+		Size        int64  `json:"size"`
+		SHA256      string `json:"sha256"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	upload := &data.Upload{
+		ContentType: input.ContentType,
+		Size:        input.Size,
+		SHA256:      input.SHA256,
+		UploadedBy:  clientIP(r),
+		ExpiresAt:   time.Now().Add(app.config.upload.presignExpiry),
+	}
+	v := validator.New()
+	if data.ValidateUpload(v, upload, app.config.upload.presignMaxBytes, presignableTypes...); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The file is uploaded as it is, with its metadata, so it is kept apart
+	// from the processed images until it is completed. Each upload gets its
+	// own key, so completing or purging one never removes a file another
+	// upload is still using. The store checks the checksum, so the client
+	// can't put other content under the key.
+	sum, _ := hex.DecodeString(upload.SHA256)
+	token := make([]byte, 16)
+	_, err = rand.Read(token)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	upload.Key = fmt.Sprintf("uploads/%x/%s%s", token, upload.SHA256, media.Extension(upload.ContentType))
+	request, err := presigner.PresignPut(upload.Key, upload.ContentType, upload.Size, sum, app.config.upload.presignExpiry)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Uploads.Insert(upload)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/uploads/%d/complete", upload.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"upload": upload, "request": request}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// completeUploadHandler checks that a presigned upload arrived with the
+// declared size and type, then processes it as /v1/upload does: the image is
+// stored with its metadata removed and its orientation applied, along with
+// its variants, and registered as an attachment. The file as uploaded is
+// deleted. Completing an upload again returns the same attachment.
+func (app *application) completeUploadHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	upload, err := app.models.Uploads.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if upload.Status == data.UploadCompleted && upload.AttachmentID != nil {
+		attachment, err := app.models.Attachments.Get(*upload.AttachmentID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		app.setAttachmentURLs(attachment)
+		err = app.writeJSON(w, http.StatusOK, envelope{"upload": upload, "attachment": attachment}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	info, err := app.blobs.Stat(upload.Key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.uploadNotReceivedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	v := validator.New()
+	v.Check(info.Size == upload.Size, "size", fmt.Sprintf("the uploaded file is %d bytes, not %d", info.Size, upload.Size))
+	v.Check(info.ContentType == upload.ContentType, "content_type", fmt.Sprintf("the uploaded file was sent as %q", info.ContentType))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	file, err := app.spoolBlob(upload.Key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	// The declared type was signed into the upload, but the content itself
+	// still has to be checked.
+	contentType, err := media.DetectImageType(file.head)
+	if err != nil || contentType != upload.ContentType {
+		app.deleteBlob(r, upload.Key)
+		app.unsupportedMediaTypeResponse(w, r, upload.ContentType)
+		return
+	}
+
+	original, variants, err := app.processImage(contentType, file)
+	if err != nil {
+		switch {
+		case errors.Is(err, media.ErrCorruptImage):
+			app.deleteBlob(r, upload.Key)
+			app.unsupportedMediaTypeResponse(w, r, upload.ContentType)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	attachment := newAttachment(r, original.storedBlob)
+	attachment.Variants = make(map[string]string, len(variants))
+	for name, variant := range variants {
+		attachment.Variants[name] = variant.Key
+	}
+	err = app.registerAttachment(attachment)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Uploads.Complete(upload, attachment.ID)
+	if err != nil && !errors.Is(err, data.ErrEditConflict) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.deleteBlob(r, upload.Key)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"upload": upload, "attachment": attachment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// spoolBlob copies a stored blob into a temporary file, so that it can be
+// processed like a file sent to /v1/upload.
+func (app *application) spoolBlob(key string) (*spooledFile, error) {
+	body, _, err := app.blobs.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	file, err := spool(func(w io.Writer) error {
+		_, err := io.Copy(w, body)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	file.head = make([]byte, min(file.size, media.SniffLen))
+	_, err = file.ReadAt(file.head, 0)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// deleteBlob deletes a file uploaded directly that is no longer needed. A
+// failure is only logged, as the upload purger doesn't know about the file
+// once its upload is completed.
+func (app *application) deleteBlob(r *http.Request, key string) {
+	err := app.blobs.Delete(key)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// startUploadPurger deletes presigned uploads that were never completed,
+// along with any file the client uploaded for them.
+func (app *application) startUploadPurger() {
+	app.runPeriodically(time.Hour, func() {
+		keys, err := app.models.Uploads.DeleteExpired(time.Now().Add(-expiredUploadGracePeriod))
+		if err != nil {
+			app.logger.Error(err.Error())
+			return
+		}
+		for _, key := range keys {
+			err := app.blobs.Delete(key)
+			if err != nil {
+				app.logger.Error(err.Error(), "key", key)
+			}
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"time"
+)
+
+// idempotencyKeyPurgeInterval is how often expired idempotency keys are
+// deleted. Expired keys are ignored on lookup, so this only reclaims space.
+const idempotencyKeyPurgeInterval = time.Hour
+
+// runPeriodically calls fn every interval on a background goroutine until the
+// application shuts down. A panic in fn is logged and only ends that run, so
+// the next one still happens.
+func (app *application) runPeriodically(interval time.Duration, fn func()) {
+	app.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.shutdown:
+				return
+			case <-ticker.C:
+				app.runRecovered(fn)
+			}
+		}
+	})
+}
+
+// startTrashPurger permanently deletes cards that have been in the trash for
+// longer than the configured retention period.
+func (app *application) startTrashPurger() {
+	app.runPeriodically(app.config.trash.purgeInterval, func() {
+		purged, err := app.models.Cards.PurgeTrash(time.Now().Add(-app.config.trash.retention))
+		if err != nil {
+			app.logger.Error(err.Error())
+			return
+		}
+		if purged > 0 {
+			app.logger.Info("purged cards from the trash", "count", purged)
+		}
+	})
+}
+
+func (app *application) startIdempotencyKeyPurger() {
+	app.runPeriodically(idempotencyKeyPurgeInterval, func() {
+		_, err := app.models.IdempotencyKeys.DeleteExpired()
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestRunPeriodicallyRecoversPanics(t *testing.T) {
+	app := &application{
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		shutdown: make(chan struct{}),
+	}
+
+	runs := make(chan int)
+	n := 0
+	app.runPeriodically(time.Millisecond, func() {
+		n++
+		if n == 1 {
+			panic("first run")
+		}
+		runs <- n
+	})
+
+	select {
+	case got := <-runs:
+		if got != 2 {
+			t.Errorf("ran %d times; want 2", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no run after the one that panicked")
+	}
+
+	close(app.shutdown)
+	app.wg.Wait()
+}
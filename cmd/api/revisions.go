@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+	"github.com/vynquoc/cs-flash-cards/internal/validator"
+)
+
+func (app *application) listCardRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	card, err := app.models.Cards.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if diff := r.URL.Query().Get("diff"); diff != "" {
+		app.diffCardRevisions(w, r, card, diff)
+		return
+	}
+
+	revisions, err := app.models.Revisions.GetAllForCard(card.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"revisions": revisions, "current_version": card.Version}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// diffCardRevisions answers ?diff=<from> (compared against the current card)
+// and ?diff=<from>,<to>.
+func (app *application) diffCardRevisions(w http.ResponseWriter, r *http.Request, card *data.Card, diff string) {
+	parts := strings.Split(diff, ",")
+	if len(parts) > 2 {
+		app.failedValidationResponse(w, r, map[string]string{"diff": "must be a revision or a pair of revisions"})
+		return
+	}
+
+	revisions := make([]*data.CardRevision, 0, 2)
+	for _, part := range parts {
+		version, err := strconv.ParseInt(strings.TrimSpace(part), 10, 32)
+		if err != nil || version < 1 {
+			app.failedValidationResponse(w, r, map[string]string{"diff": "must contain positive integer revisions"})
+			return
+		}
+		revision, err := app.cardRevision(card, int32(version))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		revisions = append(revisions, revision)
+	}
+	if len(revisions) == 1 {
+		revisions = append(revisions, data.RevisionFromCard(card))
+	}
+
+	from, to := revisions[0], revisions[1]
+	env := envelope{"diff": envelope{
+		"from":    from.Version,
+		"to":      to.Version,
+		"changes": data.DiffRevisions(from, to),
+	}}
+	err := app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// cardRevision returns the stored revision, or the card itself when the
+// requested version is the current one.
+func (app *application) cardRevision(card *data.Card, version int32) (*data.CardRevision, error) {
+	if version == card.Version {
+		return data.RevisionFromCard(card), nil
+	}
+	return app.models.Revisions.Get(card.ID, version)
+}
+
+func (app *application) restoreCardRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	version, err := app.readRevisionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	card, err := app.models.Cards.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !app.ifMatch(r, app.cardETag(card)) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+	revision, err := app.models.Revisions.Get(card.ID, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The review schedule is left alone; only the card's content is rolled back.
+	card.Title = revision.Title
+	card.Tags = revision.Tags
+	card.Content = revision.Content
+	card.CodeSnippet = revision.CodeSnippet
+	card.Description = revision.Description
+	card.Audio = revision.Audio
+	card.Occlusion = revision.Occlusion
+
+	// Rules tightened since the revision was saved, and uploads it refers to
+	// may have been collected, so it is checked like any other update.
+	v := validator.New()
+	err = app.resolveCardAudio(v, card)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if data.ValidateCard(v, card); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Cards.Update(card)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", app.cardETag(card))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"card": card}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
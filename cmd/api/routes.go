@@ -2,22 +2,94 @@ package main
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+}
+
+// routeTable lists every route served by the API. Each one must also be
+// described in openapi.json, which TestOpenAPISpec checks.
+func (app *application) routeTable() []route {
+	return []route{
+		{http.MethodGet, "/metrics", app.metricsHandler},
+		{http.MethodGet, "/v1/healthcheck", app.healthcheckHandler},
+		{http.MethodGet, "/v1/openapi.json", app.openAPIHandler},
+		{http.MethodGet, "/v1/cards", app.listCardsHandler},
+		{http.MethodPost, "/v1/cards", app.createCardHandler},
+		{http.MethodGet, "/v1/cards/:id", app.showCardHandler},
+		{http.MethodPatch, "/v1/cards/:id", app.updateCardHandler},
+		{http.MethodDelete, "/v1/cards/:id", app.deleteCardHandler},
+		{http.MethodPost, "/v1/cards/:id", app.cardActionHandler},
+		{http.MethodGet, "/v1/cards/:id/attachments", app.listCardAttachmentsHandler},
+		{http.MethodGet, "/v1/cards/:id/revisions", app.listCardRevisionsHandler},
+		{http.MethodPost, "/v1/cards/:id/revisions/:rev/restore", app.restoreCardRevisionHandler},
+		{http.MethodPost, "/v1/occlusion-cards", app.createOcclusionCardsHandler},
+		{http.MethodGet, "/v1/review-cards", app.listReviewCardHandler},
+		{http.MethodGet, "/v1/random", app.showRandomCard},
+		{http.MethodGet, "/v1/events", app.eventStreamHandler},
+		{http.MethodGet, "/v1/sync", app.pullSyncHandler},
+		{http.MethodPost, "/v1/sync", app.pushSyncHandler},
+		{http.MethodPost, "/v1/graphql", app.graphqlHandler},
+		{http.MethodGet, "/v1/tags/tree", app.showTagTreeHandler},
+		{http.MethodGet, "/v1/trash", app.listTrashHandler},
+		{http.MethodPost, "/v1/trash/:id/restore", app.restoreTrashHandler},
+		{http.MethodPost, "/v1/upload", app.uploadHandler},
+		{http.MethodPost, "/v1/uploads/:id", app.uploadActionHandler},
+		{http.MethodPost, "/v1/uploads/:id/complete", app.completeUploadHandler},
+		{http.MethodGet, "/v1/media/*path", app.showMediaHandler},
+		{http.MethodGet, "/v1/webhooks", app.listWebhooksHandler},
+		{http.MethodPost, "/v1/webhooks", app.createWebhookHandler},
+		{http.MethodGet, "/v1/webhooks/:id", app.showWebhookHandler},
+		{http.MethodDelete, "/v1/webhooks/:id", app.deleteWebhookHandler},
+		{http.MethodGet, "/v1/webhooks/:id/deliveries", app.listWebhookDeliveriesHandler},
+	}
+}
+
+// routeActions lists the POST routes ending in :id that dispatch static
+// actions, such as /v1/cards/bulk, by the value of :id.
+func (app *application) routeActions() map[string]map[string]http.HandlerFunc {
+	return map[string]map[string]http.HandlerFunc{
+		"/v1/cards/:id":   app.cardActions(),
+		"/v1/uploads/:id": app.uploadActions(),
+	}
+}
+
+// actionRoute returns the route label of a request to one of routeActions:
+// the path of the action it dispatches to, or unmatchedRoute if there is no
+// such action.
+func actionRoute(path string, actions map[string]http.HandlerFunc) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		action := httprouter.ParamsFromContext(r.Context()).ByName("id")
+		if _, ok := actions[action]; !ok {
+			return unmatchedRoute
+		}
+		return strings.TrimSuffix(path, ":id") + action
+	}
+}
+
 func (app *application) routes() http.Handler {
 	router := httprouter.New()
-	router.NotFound = http.HandlerFunc(app.notFoundResponse)
-	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/cards", app.listCardsHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/cards", app.createCardHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/cards/:id", app.showCardHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/cards/:id", app.updateCardHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/cards/:id", app.deleteCardHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/review-cards", app.listReviewCardHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/random", app.showRandomCard)
-	router.HandlerFunc(http.MethodPost, "/v1/upload", app.uploadImageHandler)
-	return app.enableCORS(router)
+	router.NotFound = app.instrument(unmatchedRoute, http.HandlerFunc(app.notFoundResponse))
+	router.MethodNotAllowed = app.instrument(unmatchedRoute, http.HandlerFunc(app.methodNotAllowedResponse))
+
+	// Route middleware is applied per route so that instrument knows the
+	// route pattern; idempotency sits inside it so replayed responses are
+	// counted too.
+	actions := app.routeActions()
+	for _, rt := range app.routeTable() {
+		handler := app.idempotency(rt.handler)
+		if rt.method == http.MethodPost && actions[rt.path] != nil {
+			router.Handler(rt.method, rt.path, app.instrumentFunc(actionRoute(rt.path, actions[rt.path]), handler))
+			continue
+		}
+		router.Handler(rt.method, rt.path, app.instrument(rt.path, handler))
+	}
+
+	return app.requestID(app.logRequest(app.enableCORS(router)))
 }
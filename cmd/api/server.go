@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+func (app *application) serve() error {
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", app.config.port),
+		Handler:           app.routes(),
+		IdleTimeout:       time.Minute,
+		ReadHeaderTimeout: 10 * time.Second,
+		// Uploads need longer than the headers to arrive, but no request can
+		// take longer than the write timeout anyway.
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+
+	srv.RegisterOnShutdown(app.events.closeAll)
+
+	grpcSrv := app.newGRPCServer()
+
+	// Stopping the background workers is shared by a signalled shutdown and
+	// the paths where the server fails, so it must only happen once.
+	stopWorkers := sync.OnceFunc(func() {
+		close(app.shutdown)
+		app.wg.Wait()
+	})
+
+	// Buffered, so that a signal arriving after Serve has already failed
+	// doesn't leave the shutdown goroutine blocked.
+	shutdownError := make(chan error, 1)
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		app.logger.Info("shutting down server", "signal", s.String())
+
+		// Stop accepting connections and give in-flight requests the grace
+		// period to finish. Requests still running after it are cut off.
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+		defer cancel()
+
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			app.logger.Warn("grace period expired, closing remaining connections", "timeout", app.config.shutdownTimeout.String())
+			srv.Close()
+		}
+		app.stopGRPC(ctx, grpcSrv)
+
+		// Stop the background workers and wait for them, and for any tasks
+		// started by requests, to return.
+		app.logger.Info("completing background tasks", "addr", srv.Addr)
+		stopWorkers()
+
+		shutdownError <- err
+	}()
+
+	ln, inherited, err := listen(srv.Addr)
+	if err != nil {
+		// The server never started, so stop the background workers here
+		// rather than on a signal, before the caller closes the pool.
+		stopWorkers()
+		return err
+	}
+
+	if app.config.grpcPort != 0 {
+		grpcLn, err := net.Listen("tcp", fmt.Sprintf(":%d", app.config.grpcPort))
+		if err != nil {
+			ln.Close()
+			stopWorkers()
+			return err
+		}
+		app.logger.Info("starting grpc server", "addr", grpcLn.Addr().String())
+
+		go func() {
+			// Serve only returns an error if the listener fails; the JSON API
+			// keeps running without it.
+			err := grpcSrv.Serve(grpcLn)
+			if err != nil {
+				app.logger.Error(err.Error(), "addr", grpcLn.Addr().String())
+			}
+		}()
+	}
+
+	app.logger.Info("starting server", "addr", ln.Addr().String(), "env", app.config.env, "socket_activated", inherited)
+
+	err = srv.Serve(ln)
+	if !errors.Is(err, http.ErrServerClosed) {
+		// The listener failed, and no signal is coming to stop the rest, so
+		// do it here as when the server never started.
+		grpcSrv.Stop()
+		stopWorkers()
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("stopped server", "addr", srv.Addr)
+	return nil
+}
+
+// listen returns the socket passed in by systemd when the server is started
+// through socket activation (remote/production/api.socket), and otherwise
+// listens on addr itself. systemd holds the socket open while the service
+// restarts, so connections made during a deploy queue up for the new process
+// instead of being refused.
+func listen(addr string) (net.Listener, bool, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		ln, err := net.Listen("tcp", addr)
+		return ln, false, err
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds != 1 {
+		return nil, false, fmt.Errorf("expected one socket from systemd, got LISTEN_FDS=%q", os.Getenv("LISTEN_FDS"))
+	}
+
+	// Passed sockets start at file descriptor 3.
+	f := os.NewFile(3, "systemd socket")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, false, err
+	}
+	return ln, true, nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+	"github.com/vynquoc/cs-flash-cards/internal/validator"
+)
+
+const (
+	syncStatusMerged    = "merged"
+	syncStatusDuplicate = "duplicate"
+	syncStatusApplied   = "applied"
+	syncStatusConflict  = "conflict"
+	syncStatusNotFound  = "not_found"
+	syncStatusInvalid   = "invalid"
+	syncStatusError     = "error"
+)
+
+type syncReviewInput struct {
+	ClientID       string    `json:"client_id"`
+	CardID         int64     `json:"card_id"`
+	ReviewedAt     time.Time `json:"reviewed_at"`
+	Grade          *int      `json:"grade"`
+	NextReviewDate time.Time `json:"next_review_date"`
+}
+
+type syncEditInput struct {
+	CardID      int64             `json:"card_id"`
+	BaseVersion int32             `json:"base_version"`
+	EditedAt    time.Time         `json:"edited_at"`
+	Title       *string           `json:"title"`
+	Tags        []string          `json:"tags"`
+	Content     *string           `json:"content"`
+	CodeSnippet *data.CodeSnippet `json:"code_snippet"`
+	Description *string           `json:"description"`
+	Suspended   *bool             `json:"suspended"`
+	Audio       *data.CardAudio   `json:"audio"`
+}
+
+type syncReviewResult struct {
+	ClientID string            `json:"client_id"`
+	Status   string            `json:"status"`
+	Errors   map[string]string `json:"errors,omitempty"`
+}
+
+type syncEditResult struct {
+	CardID int64             `json:"card_id"`
+	Status string            `json:"status"`
+	Card   *data.Card        `json:"card,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// pullSyncHandler returns the cards, deletions and reviews that changed since
+// the since token, along with the token to pass next time. Clients keep
+// pulling while has_more is true.
+func (app *application) pullSyncHandler(w http.ResponseWriter, r *http.Request) {
+	var since *data.SyncToken
+	if s := app.readString(r.URL.Query(), "since", ""); s != "" {
+		token, err := data.DecodeSyncToken(s)
+		if err != nil {
+			app.failedValidationResponse(w, r, map[string]string{"since": "invalid sync token"})
+			return
+		}
+		since = token
+	}
+
+	changes, err := app.models.Sync.Changes(since)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrSyncTokenExpired):
+			app.syncTokenExpiredResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"cards":      changes.Cards,
+		"tombstones": changes.Tombstones,
+		"reviews":    changes.Reviews,
+		"has_more":   changes.HasMore,
+		"sync_token": changes.Token.Encode(),
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// pushSyncHandler uploads reviews and edits made offline. Reviews are merged
+// into the review log. Edits are applied in edited_at order and only if the
+// card is still at the edit's base_version; otherwise the server's copy wins
+// and is sent back as a conflict for the client to resolve. Each review and
+// edit is applied on its own, so one that fails unexpectedly is reported with
+// the error status for the client to push again, rather than failing the
+// whole request after the others have been applied. Pushing again is safe:
+// reviews are merged by client_id and edits are checked against their
+// base_version.
+func (app *application) pushSyncHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Reviews []syncReviewInput `json:"reviews"`
+		Edits   []syncEditInput   `json:"edits"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Reviews)+len(input.Edits) > 0, "body", "must contain at least 1 review or edit")
+	v.Check(len(input.Reviews)+len(input.Edits) <= data.MaxSyncChanges, "body", fmt.Sprintf("must not contain more than %d reviews and edits", data.MaxSyncChanges))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews := make([]syncReviewResult, len(input.Reviews))
+	for i, in := range input.Reviews {
+		review := &data.Review{
+			ClientID:       in.ClientID,
+			CardID:         in.CardID,
+			ReviewedAt:     in.ReviewedAt,
+			Grade:          in.Grade,
+			NextReviewDate: in.NextReviewDate,
+		}
+		reviews[i], err = app.mergeReview(review)
+		if err != nil {
+			app.logError(r, err)
+			reviews[i] = syncReviewResult{ClientID: in.ClientID, Status: syncStatusError}
+		}
+	}
+
+	sort.SliceStable(input.Edits, func(i, j int) bool {
+		return input.Edits[i].EditedAt.Before(input.Edits[j].EditedAt)
+	})
+	edits := make([]syncEditResult, len(input.Edits))
+	for i, edit := range input.Edits {
+		edits[i], err = app.applyEdit(edit)
+		if err != nil {
+			app.logError(r, err)
+			edits[i] = syncEditResult{CardID: edit.CardID, Status: syncStatusError}
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reviews": reviews, "edits": edits}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) mergeReview(review *data.Review) (syncReviewResult, error) {
+	result := syncReviewResult{ClientID: review.ClientID}
+
+	v := validator.New()
+	if data.ValidateReview(v, review); !v.Valid() {
+		result.Status = syncStatusInvalid
+		result.Errors = v.Errors
+		return result, nil
+	}
+
+	merged, err := app.models.Reviews.Merge(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			result.Status = syncStatusNotFound
+			return result, nil
+		default:
+			return result, err
+		}
+	}
+	if !merged {
+		result.Status = syncStatusDuplicate
+		return result, nil
+	}
+
+	app.recordReview(review.Grade)
+	result.Status = syncStatusMerged
+	return result, nil
+}
+
+func (app *application) applyEdit(edit syncEditInput) (syncEditResult, error) {
+	result := syncEditResult{CardID: edit.CardID}
+
+	card, err := app.models.Cards.Get(edit.CardID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			result.Status = syncStatusNotFound
+			return result, nil
+		default:
+			return result, err
+		}
+	}
+	if card.Version != edit.BaseVersion {
+		result.Status = syncStatusConflict
+		result.Card = card
+		return result, nil
+	}
+
+	if edit.Title != nil {
+		card.Title = *edit.Title
+	}
+	if edit.Content != nil {
+		card.Content = *edit.Content
+	}
+	if edit.Tags != nil {
+		card.Tags = edit.Tags
+	}
+	if edit.CodeSnippet != nil {
+		card.CodeSnippet = edit.CodeSnippet
+	}
+	if edit.Description != nil {
+		card.Description = *edit.Description
+	}
+	if edit.Suspended != nil {
+		card.Suspended = *edit.Suspended
+	}
+	if edit.Audio != nil {
+		card.Audio = audioUpdate(edit.Audio)
+	}
+
+	v := validator.New()
+	err = app.resolveCardAudio(v, card)
+	if err != nil {
+		return result, err
+	}
+	if data.ValidateCard(v, card); !v.Valid() {
+		result.Status = syncStatusInvalid
+		result.Errors = v.Errors
+		return result, nil
+	}
+
+	err = app.models.Cards.Update(card)
+	if err != nil {
+		if !errors.Is(err, data.ErrEditConflict) {
+			return result, err
+		}
+		// Lost a race with another writer, so report its version instead.
+		current, err := app.models.Cards.Get(edit.CardID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				result.Status = syncStatusNotFound
+				return result, nil
+			default:
+				return result, err
+			}
+		}
+		result.Status = syncStatusConflict
+		result.Card = current
+		return result, nil
+	}
+
+	result.Status = syncStatusApplied
+	result.Card = card
+	return result, nil
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/vynquoc/cs-flash-cards/internal/media"
+	"github.com/vynquoc/cs-flash-cards/internal/storage"
+)
+
+var (
+	errUploadTooLarge   = errors.New("upload too large")
+	errUploadFieldEmpty = errors.New("upload field missing")
+)
+
+// spooledFile is an uploaded file copied to a temporary file, so that it can
+// be inspected and re-read without holding it in memory. Close removes it.
+type spooledFile struct {
+	*os.File
+	size int64
+	head []byte
+}
+
+func (f *spooledFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// spool runs write against a new temporary file, for files derived from an
+// upload such as its sanitized or resized versions, and returns the file
+// ready to be read from the start.
+func spool(write func(w io.Writer) error) (*spooledFile, error) {
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	f := &spooledFile{File: tmp}
+
+	bw := bufio.NewWriter(tmp)
+	err = write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		f.size, err = tmp.Seek(0, io.SeekCurrent)
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// storedBlob describes a file saved to the blob store.
+type storedBlob struct {
+	Key          string `json:"key"`
+	URL          string `json:"url"`
+	ContentType  string `json:"content_type"`
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256"`
+	Deduplicated bool   `json:"deduplicated"`
+}
+
+// readUploadPart streams the first of the named fields in a multipart request
+// body into a temporary file. It fails with errUploadTooLarge as soon as the
+// file grows past maxBytes, and with errUploadFieldEmpty if there is no such
+// field.
+func (app *application) readUploadPart(w http.ResponseWriter, r *http.Request, fields []string, maxBytes int64) (*spooledFile, error) {
+	// Leave room for the multipart headers and any other small fields.
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+1<<20)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, errUploadFieldEmpty
+		}
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				return nil, errUploadTooLarge
+			}
+			return nil, err
+		}
+		if !slices.Contains(fields, part.FormName()) {
+			part.Close()
+			continue
+		}
+		defer part.Close()
+
+		tmp, err := os.CreateTemp("", "upload-*")
+		if err != nil {
+			return nil, err
+		}
+		f := &spooledFile{File: tmp}
+
+		f.size, err = io.Copy(tmp, io.LimitReader(part, maxBytes+1))
+		if err != nil {
+			f.Close()
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				return nil, errUploadTooLarge
+			}
+			return nil, err
+		}
+		if f.size > maxBytes {
+			f.Close()
+			return nil, errUploadTooLarge
+		}
+		if f.size == 0 {
+			f.Close()
+			return nil, errUploadFieldEmpty
+		}
+
+		f.head = make([]byte, min(f.size, media.SniffLen))
+		_, err = tmp.ReadAt(f.head, 0)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		_, err = tmp.Seek(0, io.SeekStart)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+}
+
+// storeBlob saves body, read from the start, under a key derived from its
+// SHA-256 hash, so that identical files share one blob and a key can never be
+// chosen by a client. If the blob already exists it is not uploaded again.
+func (app *application) storeBlob(prefix string, body io.ReadSeeker, contentType string) (*storedBlob, error) {
+	_, err := body.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, body)
+	if err != nil {
+		return nil, err
+	}
+	_, err = body.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	blob := &storedBlob{
+		Key:         fmt.Sprintf("%s/%s%s", prefix, sum, media.Extension(contentType)),
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      sum,
+	}
+	blob.URL = app.blobs.URL(blob.Key)
+
+	_, err = app.blobs.Stat(blob.Key)
+	switch {
+	case err == nil:
+		blob.Deduplicated = true
+		return blob, nil
+	case !errors.Is(err, storage.ErrNotFound):
+		return nil, err
+	}
+
+	err = app.blobs.Put(blob.Key, body, contentType)
+	if err != nil {
+		app.metrics.uploadFailures.With(app.config.storage.backend).Inc()
+		return nil, err
+	}
+	return blob, nil
+}
+
+// Longest side, in pixels, of the variants generated for uploaded images.
+const (
+	displayMaxSize   = 1600
+	thumbnailMaxSize = 320
+)
+
+// storedImage is a stored image along with its dimensions, which are unknown
+// for formats that can't be decoded here (SVG and WebP).
+type storedImage struct {
+	*storedBlob
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+}
+
+// storedAudio is a stored audio file along with its playing time.
+type storedAudio struct {
+	*storedBlob
+	DurationMS int64 `json:"duration_ms"`
+}
+
+// uploadHandler stores an image or audio file, sent in a multipart field
+// named image or audio. The file's type is detected from its content.
+func (app *application) uploadHandler(w http.ResponseWriter, r *http.Request) {
+	file, err := app.readUploadPart(w, r, []string{"image", "audio"}, app.config.upload.maxBytes)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUploadTooLarge):
+			app.uploadTooLargeResponse(w, r, app.config.upload.maxBytes)
+		case errors.Is(err, errUploadFieldEmpty):
+			app.failedValidationResponse(w, r, map[string]string{"image": "an image or audio file must be provided"})
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	if contentType, err := media.DetectImageType(file.head); err == nil {
+		app.storeImage(w, r, contentType, file)
+		return
+	}
+	if contentType, err := media.DetectAudioType(file.head); err == nil {
+		app.storeAudio(w, r, contentType, file)
+		return
+	}
+	app.unsupportedMediaTypeResponse(w, r, uploadTypes)
+}
+
+// uploadTypes lists the file types uploadHandler accepts, for error messages.
+const uploadTypes = "png, jpeg, gif, webp, svg, mp3, ogg (vorbis or opus) or m4a"
+
+func (app *application) storeImage(w http.ResponseWriter, r *http.Request, contentType string, file *spooledFile) {
+	original, variants, err := app.processImage(contentType, file)
+	if err != nil {
+		switch {
+		case errors.Is(err, media.ErrInvalidSVG), errors.Is(err, media.ErrCorruptImage):
+			app.unsupportedMediaTypeResponse(w, r, uploadTypes)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	attachment := newAttachment(r, original.storedBlob)
+	attachment.Variants = make(map[string]string, len(variants))
+	for name, variant := range variants {
+		attachment.Variants[name] = variant.Key
+	}
+	err = app.registerAttachment(attachment)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"image_url": original.URL, "image": original, "variants": variants, "attachment": attachment}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) storeAudio(w http.ResponseWriter, r *http.Request, contentType string, file *spooledFile) {
+	duration, err := media.AudioDuration(contentType, file)
+	if err != nil {
+		app.unsupportedMediaTypeResponse(w, r, uploadTypes)
+		return
+	}
+
+	blob, err := app.storeBlob("audio", file, contentType)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	audio := &storedAudio{storedBlob: blob, DurationMS: duration.Milliseconds()}
+
+	attachment := newAttachment(r, blob)
+	attachment.DurationMS = &audio.DurationMS
+	err = app.registerAttachment(attachment)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"audio_url": audio.URL, "audio": audio, "attachment": attachment}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// processImage stores an uploaded image with its metadata removed, plus a
+// display-sized variant and a thumbnail. Variants of images that are already
+// small enough, or that can't be decoded, are the original image itself.
+// Each step writes to a new temporary file, so the only image held in memory
+// is the decoded one, which media.Decode bounds.
+func (app *application) processImage(contentType string, file *spooledFile) (*storedImage, map[string]*storedImage, error) {
+	src := file
+	if contentType == media.TypeSVG {
+		sanitized, err := spool(func(w io.Writer) error { return media.SanitizeSVG(w, file) })
+		if err != nil {
+			return nil, nil, err
+		}
+		defer sanitized.Close()
+		src = sanitized
+	}
+
+	// Stripping EXIF also removes its orientation, so rotated JPEGs have the
+	// rotation applied to the pixels instead.
+	var img image.Image
+	if contentType == media.TypeJPEG {
+		if orientation := media.JPEGOrientation(src); orientation != 1 {
+			decoded, err := media.Decode(contentType, src)
+			switch {
+			case err == nil:
+				img = media.Orient(decoded, orientation)
+				oriented, err := spool(func(w io.Writer) error {
+					_, err := media.Encode(w, img, contentType)
+					return err
+				})
+				if err != nil {
+					return nil, nil, err
+				}
+				defer oriented.Close()
+				src = oriented
+			case !errors.Is(err, media.ErrImageTooLarge):
+				return nil, nil, err
+			}
+		}
+	}
+
+	stripped, err := spool(func(w io.Writer) error { return media.StripMetadata(w, contentType, src) })
+	if err != nil {
+		return nil, nil, err
+	}
+	defer stripped.Close()
+
+	// The image is decoded before anything is stored, so that a corrupt
+	// upload is rejected without leaving a blob behind.
+	if img == nil {
+		img, err = media.Decode(contentType, stripped)
+		switch {
+		case errors.Is(err, media.ErrUnsupportedType), errors.Is(err, media.ErrImageTooLarge):
+			img = nil
+		case err != nil:
+			return nil, nil, err
+		}
+	}
+
+	blob, err := app.storeBlob("images", stripped, contentType)
+	if err != nil {
+		return nil, nil, err
+	}
+	original := &storedImage{storedBlob: blob}
+	variants := map[string]*storedImage{"display": original, "thumbnail": original}
+	if img == nil {
+		return original, variants, nil
+	}
+	original.Width, original.Height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	for name, maxSize := range map[string]int{"display": displayMaxSize, "thumbnail": thumbnailMaxSize} {
+		width, height := media.Fit(original.Width, original.Height, maxSize)
+		if width == original.Width && height == original.Height {
+			continue
+		}
+		variant, err := app.storeVariant(media.Resize(img, width, height), contentType)
+		if err != nil {
+			return nil, nil, err
+		}
+		variant.Width, variant.Height = width, height
+		variants[name] = variant
+	}
+	return original, variants, nil
+}
+
+// storeVariant encodes and stores an image generated from an upload.
+func (app *application) storeVariant(img image.Image, sourceType string) (*storedImage, error) {
+	var contentType string
+	encoded, err := spool(func(w io.Writer) error {
+		var err error
+		contentType, err = media.Encode(w, img, sourceType)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer encoded.Close()
+
+	blob, err := app.storeBlob("images", encoded, contentType)
+	if err != nil {
+		return nil, err
+	}
+	return &storedImage{storedBlob: blob}, nil
+}
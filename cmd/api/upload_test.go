@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vynquoc/cs-flash-cards/internal/media"
+	"github.com/vynquoc/cs-flash-cards/internal/storage"
+)
+
+func TestProcessImage(t *testing.T) {
+	var valid bytes.Buffer
+	err := png.Encode(&valid, image.NewRGBA(image.Rect(0, 0, 4, 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A JPEG whose segments are well formed, so stripping succeeds, but with
+	// no frame header to decode.
+	corrupt := []byte{0xff, 0xd8, 0xff, 0xda, 0x00, 0x02, 0x12, 0x34, 0xff, 0xd9}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		wantErr     error
+		wantBlobs   int
+	}{
+		{"valid", media.TypePNG, valid.Bytes(), nil, 1},
+		{"corrupt", media.TypeJPEG, corrupt, media.ErrCorruptImage, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			blobs, err := storage.NewLocalStore(root, "http://localhost/v1/media")
+			if err != nil {
+				t.Fatal(err)
+			}
+			app := &application{blobs: blobs}
+
+			file, err := spool(func(w io.Writer) error {
+				_, err := w.Write(tt.body)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			original, _, err := app.processImage(tt.contentType, file)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("processImage() error = %v; want %v", err, tt.wantErr)
+			}
+			if err == nil && (original.Width != 4 || original.Height != 3) {
+				t.Errorf("original is %dx%d; want 4x3", original.Width, original.Height)
+			}
+
+			stored := 0
+			err = filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					stored++
+				}
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored != tt.wantBlobs {
+				t.Errorf("stored %d blobs; want %d", stored, tt.wantBlobs)
+			}
+		})
+	}
+}
+
+func TestSpoolBlob(t *testing.T) {
+	blobs, err := storage.NewLocalStore(t.TempDir(), "http://localhost/v1/media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &application{blobs: blobs}
+
+	body := bytes.Repeat([]byte("0123456789"), 100)
+	err = blobs.Put("uploads/abc/file.png", bytes.NewReader(body), media.TypePNG)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := app.spoolBlob("uploads/abc/file.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if file.size != int64(len(body)) {
+		t.Errorf("size = %d; want %d", file.size, len(body))
+	}
+	if !bytes.Equal(file.head, body[:media.SniffLen]) {
+		t.Errorf("head = %q; want the first %d bytes", file.head, media.SniffLen)
+	}
+	got, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("spooled file differs from the blob")
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+	"github.com/vynquoc/cs-flash-cards/internal/validator"
+)
+
+const (
+	webhookDispatchInterval = 5 * time.Second
+	webhookDueCheckInterval = time.Hour
+	webhookBatchSize        = 10
+	webhookLease            = time.Minute
+	webhookTimeout          = 10 * time.Second
+	webhookMaxAttempts      = 10
+	webhookBaseBackoff      = 30 * time.Second
+	webhookMaxBackoff       = 6 * time.Hour
+)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	webhook := &data.Webhook{
+		URL:    input.URL,
+		Secret: hex.EncodeToString(secret),
+		Events: input.Events,
+		Active: true,
+	}
+
+	v := validator.New()
+	if data.ValidateWebhook(v, webhook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Insert(webhook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d", webhook.ID))
+
+	// The signing secret is only ever returned here, when the webhook is created.
+	err = app.writeJSON(w, http.StatusCreated, envelope{"webhook": webhook, "secret": webhook.Secret}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := app.models.Webhooks.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhooks": webhooks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	webhook, err := app.models.Webhooks.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Webhooks.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "webhook successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var filters data.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = "-id"
+	filters.SortSafeList = []string{"-id"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Webhooks.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	deliveries, metadata, err := app.models.Webhooks.GetDeliveries(id, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"deliveries": deliveries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// startWebhookDispatcher delivers queued webhook events and checks hourly
+// whether a cards.due digest needs to go out.
+func (app *application) startWebhookDispatcher() {
+	app.runPeriodically(webhookDispatchInterval, app.deliverWebhooks)
+
+	app.runPeriodically(webhookDueCheckInterval, func() {
+		due, err := app.models.Cards.CountDue()
+		if err != nil {
+			app.logger.Error(err.Error())
+			return
+		}
+		if due == 0 {
+			return
+		}
+		payload := envelope{"due_count": due, "date": time.Now().Format(time.DateOnly)}
+		err = app.models.Webhooks.EnqueueOncePerDay(data.EventCardsDue, payload)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+}
+
+func (app *application) deliverWebhooks() {
+	deliveries, err := app.models.Webhooks.ClaimDue(webhookBatchSize, webhookLease)
+	if err != nil {
+		app.logger.Error(err.Error())
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func(delivery *data.WebhookDelivery) {
+			defer wg.Done()
+			app.deliverWebhook(delivery)
+		}(delivery)
+	}
+	wg.Wait()
+}
+
+func (app *application) deliverWebhook(delivery *data.WebhookDelivery) {
+	body, err := json.Marshal(envelope{
+		"id":         delivery.ID,
+		"event":      delivery.Event,
+		"created_at": delivery.CreatedAt,
+		"data":       delivery.Payload,
+	})
+	if err != nil {
+		app.logger.Error(err.Error())
+		return
+	}
+
+	attempt := &data.WebhookAttempt{AttemptedAt: time.Now()}
+	statusCode, err := app.postWebhook(delivery, body)
+	attempt.DurationMS = int(time.Since(attempt.AttemptedAt).Milliseconds())
+	if statusCode != 0 {
+		attempt.StatusCode = &statusCode
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	status := data.DeliverySucceeded
+	nextAttemptAt := delivery.NextAttemptAt
+	if err != nil {
+		status = data.DeliveryPending
+		nextAttemptAt = time.Now().Add(webhookBackoff(delivery.Attempts + 1))
+		if delivery.Attempts+1 >= webhookMaxAttempts {
+			status = data.DeliveryFailed
+		}
+	}
+
+	err = app.models.Webhooks.RecordAttempt(delivery, attempt, status, nextAttemptAt)
+	if err != nil {
+		app.logger.Error(err.Error())
+	}
+}
+
+// postWebhook sends a signed delivery. The signature is an HMAC-SHA256 of the
+// timestamp and body, joined by a dot, keyed with the webhook's secret.
+func (app *application) postWebhook(delivery *data.WebhookDelivery, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, delivery.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(delivery.Webhook.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cs-flash-cards-webhooks/"+version)
+	req.Header.Set("X-Webhook-ID", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected response status %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// webhookBackoff doubles the wait after every failed attempt, starting at
+// webhookBaseBackoff and capped at webhookMaxBackoff.
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookBaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= webhookMaxBackoff {
+			return webhookMaxBackoff
+		}
+	}
+	return backoff
+}
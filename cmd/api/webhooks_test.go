@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+)
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, webhookBaseBackoff},
+		{1, webhookBaseBackoff},
+		{2, 2 * webhookBaseBackoff},
+		{3, 4 * webhookBaseBackoff},
+		{9, 256 * webhookBaseBackoff},
+		{10, 512 * webhookBaseBackoff},
+		{11, webhookMaxBackoff},
+		{1000, webhookMaxBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := webhookBackoff(tt.attempts); got != tt.want {
+			t.Errorf("webhookBackoff(%d) = %v; want %v", tt.attempts, got, tt.want)
+		}
+	}
+
+	// Every retry before the last attempt waits at least as long as the one
+	// before it.
+	for attempts := 2; attempts < webhookMaxAttempts; attempts++ {
+		if webhookBackoff(attempts) < webhookBackoff(attempts-1) {
+			t.Errorf("webhookBackoff(%d) is shorter than webhookBackoff(%d)", attempts, attempts-1)
+		}
+	}
+}
+
+func TestPostWebhookSignature(t *testing.T) {
+	const secret = "shh"
+	body := []byte(`{"type":"card.created"}`)
+
+	var got *http.Request
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	app := &application{}
+	delivery := &data.WebhookDelivery{
+		ID:      7,
+		Event:   "card.created",
+		Webhook: &data.Webhook{URL: srv.URL, Secret: secret},
+	}
+
+	status, err := app.postWebhook(delivery, body)
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("postWebhook = %d, %v; want 204", status, err)
+	}
+	if string(gotBody) != string(body) {
+		t.Errorf("body %s; want %s", gotBody, body)
+	}
+	if id := got.Header.Get("X-Webhook-ID"); id != "7" {
+		t.Errorf("X-Webhook-ID %q; want 7", id)
+	}
+	if event := got.Header.Get("X-Webhook-Event"); event != "card.created" {
+		t.Errorf("X-Webhook-Event %q; want card.created", event)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(got.Header.Get("X-Webhook-Timestamp") + "."))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if sig := got.Header.Get("X-Webhook-Signature"); sig != want {
+		t.Errorf("X-Webhook-Signature %q; want %q", sig, want)
+	}
+}
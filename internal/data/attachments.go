@@ -0,0 +1,284 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// mediaKeyRX matches the content-addressed storage keys that uploads are
+// saved under, wherever they appear in a media URL.
+var mediaKeyRX = regexp.MustCompile(`\b[a-z]+/[0-9a-f]{64}\.[a-z0-9]+\b`)
+
+// Attachment is an uploaded file. Variants maps variant names, such as
+// "thumbnail", to the storage keys of derived files that belong to it. The
+// URL fields are filled in by the caller, which knows where blobs are served.
+type Attachment struct {
+	ID          int64             `json:"id"`
+	CreatedAt   time.Time         `json:"created_at"`
+	Key         string            `json:"key"`
+	URL         string            `json:"url"`
+	ContentType string            `json:"content_type"`
+	Size        int64             `json:"size"`
+	SHA256      string            `json:"sha256"`
+	UploadedBy  string            `json:"uploaded_by"`
+	Variants    map[string]string `json:"-"`
+	VariantURLs map[string]string `json:"variants"`
+	DurationMS  *int64            `json:"duration_ms,omitempty"`
+}
+
+// Keys returns the storage keys of the attachment and all its variants.
+func (a *Attachment) Keys() []string {
+	keys := []string{a.Key}
+	for _, key := range a.Variants {
+		if key != a.Key {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+type AttachmentModel struct {
+	DB *sql.DB
+}
+
+// MediaKeys returns the storage keys referenced by media URLs in texts.
+func MediaKeys(texts ...string) []string {
+	seen := map[string]bool{}
+	keys := []string{}
+	for _, text := range texts {
+		for _, key := range mediaKeyRX.FindAllString(text, -1) {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+func cardMediaKeys(card *Card) []string {
+	texts := []string{card.Content, card.Description}
+	if card.Audio != nil {
+		texts = append(texts, card.Audio.URL)
+	}
+	if card.Occlusion != nil {
+		texts = append(texts, card.Occlusion.ImageURL)
+	}
+	return MediaKeys(texts...)
+}
+
+// linkAttachments makes the card's links match the attachments whose keys
+// are in keys. Attachments that lose a link have unlinked_at reset, so they
+// get the full retention period before the collector considers them.
+func linkAttachments(tx *sql.Tx, cardID int64, keys []string) error {
+	query := `
+		WITH wanted AS (
+			SELECT id FROM attachments WHERE keys && $2::text[]
+		), removed AS (
+			DELETE FROM card_attachments
+			WHERE card_id = $1 AND attachment_id NOT IN (SELECT id FROM wanted)
+			RETURNING attachment_id
+		), unlinked AS (
+			UPDATE attachments SET unlinked_at = NOW()
+			WHERE id IN (SELECT attachment_id FROM removed)
+		)
+		INSERT INTO card_attachments (card_id, attachment_id)
+		SELECT $1, id FROM wanted
+		ON CONFLICT DO NOTHING
+	`
+	_, err := tx.Exec(query, cardID, pq.Array(keys))
+	return err
+}
+
+// Insert records an attachment. Uploads are content-addressed, so uploading
+// the same file again returns the existing attachment, with its retention
+// period restarted.
+func (m AttachmentModel) Insert(attachment *Attachment) error {
+	if attachment.Variants == nil {
+		attachment.Variants = map[string]string{}
+	}
+	variants, err := json.Marshal(attachment.Variants)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO attachments (key, content_type, size, sha256, uploaded_by, variants, keys, duration_ms)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (key) DO UPDATE SET unlinked_at = NOW()
+		RETURNING id, created_at, uploaded_by
+	`
+	args := []interface{}{
+		attachment.Key,
+		attachment.ContentType,
+		attachment.Size,
+		attachment.SHA256,
+		attachment.UploadedBy,
+		variants,
+		pq.Array(attachment.Keys()),
+		attachment.DurationMS,
+	}
+	return m.DB.QueryRow(query, args...).Scan(&attachment.ID, &attachment.CreatedAt, &attachment.UploadedBy)
+}
+
+func (m AttachmentModel) Get(id int64) (*Attachment, error) {
+	query := `
+		SELECT id, created_at, key, content_type, size, sha256, uploaded_by, variants, duration_ms
+		FROM attachments
+		WHERE id = $1
+	`
+	attachments, err := m.queryAttachments(query, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(attachments) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return attachments[0], nil
+}
+
+// GetByKey returns the attachment stored under key.
+func (m AttachmentModel) GetByKey(key string) (*Attachment, error) {
+	query := `
+		SELECT id, created_at, key, content_type, size, sha256, uploaded_by, variants, duration_ms
+		FROM attachments
+		WHERE key = $1
+	`
+	attachments, err := m.queryAttachments(query, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(attachments) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return attachments[0], nil
+}
+
+func scanAttachment(rows *sql.Rows) (*Attachment, error) {
+	var attachment Attachment
+	var variants []byte
+	err := rows.Scan(
+		&attachment.ID,
+		&attachment.CreatedAt,
+		&attachment.Key,
+		&attachment.ContentType,
+		&attachment.Size,
+		&attachment.SHA256,
+		&attachment.UploadedBy,
+		&variants,
+		&attachment.DurationMS,
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(variants, &attachment.Variants)
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+func (m AttachmentModel) queryAttachments(query string, args ...interface{}) ([]*Attachment, error) {
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := []*Attachment{}
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// GetForCard returns the attachments linked to a card that isn't in the
+// trash.
+func (m AttachmentModel) GetForCard(cardID int64) ([]*Attachment, error) {
+	var exists bool
+	err := m.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM cards WHERE id = $1 AND deleted_at IS NULL)`, cardID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT a.id, a.created_at, a.key, a.content_type, a.size, a.sha256, a.uploaded_by, a.variants, a.duration_ms
+		FROM attachments a
+		JOIN card_attachments ca ON ca.attachment_id = a.id
+		WHERE ca.card_id = $1
+		ORDER BY a.id
+	`
+	return m.queryAttachments(query, cardID)
+}
+
+// GetUnlinked returns up to limit attachments that no card, including cards
+// in the trash, has linked to since before the cutoff.
+func (m AttachmentModel) GetUnlinked(cutoff time.Time, limit int) ([]*Attachment, error) {
+	query := `
+		SELECT id, created_at, key, content_type, size, sha256, uploaded_by, variants, duration_ms
+		FROM attachments a
+		WHERE unlinked_at < $1
+		AND NOT EXISTS (SELECT 1 FROM card_attachments WHERE attachment_id = a.id)
+		ORDER BY unlinked_at
+		LIMIT $2
+	`
+	return m.queryAttachments(query, cutoff, limit)
+}
+
+// DeleteUnlinked deletes an attachment found by GetUnlinked, unless it has
+// been linked or uploaded again since. It returns the storage keys that no
+// remaining attachment uses, which are safe to remove from the blob store.
+func (m AttachmentModel) DeleteUnlinked(attachment *Attachment, cutoff time.Time) ([]string, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM attachments a
+		WHERE id = $1 AND unlinked_at < $2
+		AND NOT EXISTS (SELECT 1 FROM card_attachments WHERE attachment_id = a.id)
+	`
+	result, err := tx.Exec(query, attachment.ID, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	query = `
+		SELECT coalesce(array_agg(key), '{}')
+		FROM unnest($1::text[]) AS key
+		WHERE NOT EXISTS (SELECT 1 FROM attachments WHERE keys @> ARRAY[key])
+	`
+	var keys []string
+	err = tx.QueryRow(query, pq.Array(attachment.Keys())).Scan(pq.Array(&keys))
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
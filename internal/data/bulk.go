@@ -0,0 +1,258 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/vynquoc/cs-flash-cards/internal/validator"
+)
+
+const (
+	BulkAddTags       = "add_tags"
+	BulkRemoveTags    = "remove_tags"
+	BulkReschedule    = "reschedule"
+	BulkResetProgress = "reset_progress"
+	BulkDelete        = "delete"
+	BulkSuspend       = "suspend"
+	BulkUnsuspend     = "unsuspend"
+
+	// MaxBulkCards caps how many cards a single bulk operation may touch.
+	MaxBulkCards = 1000
+)
+
+var ErrTooManyCards = errors.New("too many cards")
+
+var BulkActions = []string{
+	BulkAddTags,
+	BulkRemoveTags,
+	BulkReschedule,
+	BulkResetProgress,
+	BulkDelete,
+	BulkSuspend,
+	BulkUnsuspend,
+}
+
+type BulkFilter struct {
+	Title string   `json:"title"`
+	Tags  []string `json:"tags"`
+}
+
+// BulkOperation applies one action to either a list of card ids or every card
+// matching a filter.
+type BulkOperation struct {
+	Action         string
+	IDs            []int64
+	Filter         *BulkFilter
+	Tags           []string
+	NextReviewDate time.Time
+}
+
+type BulkResult struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+const (
+	BulkStatusOK       = "ok"
+	BulkStatusNotFound = "not_found"
+	BulkStatusInvalid  = "invalid"
+)
+
+func ValidateBulkOperation(v *validator.Validator, op *BulkOperation) {
+	v.Check(validator.In(op.Action, BulkActions...), "action", "invalid action value")
+	v.Check((op.IDs == nil) != (op.Filter == nil), "ids", "exactly one of ids or filter must be provided")
+	if op.IDs != nil {
+		v.Check(len(op.IDs) >= 1, "ids", "must contain at least 1 id")
+		v.Check(len(op.IDs) <= MaxBulkCards, "ids", fmt.Sprintf("must not contain more than %d ids", MaxBulkCards))
+	}
+	if op.Filter != nil {
+		v.Check(op.Filter.Title != "" || len(op.Filter.Tags) > 0, "filter", "must contain a title or tags")
+	}
+
+	switch op.Action {
+	case BulkAddTags, BulkRemoveTags:
+		v.Check(len(op.Tags) >= 1, "tags", "must contain at least 1 tag")
+		for _, tag := range op.Tags {
+			v.Check(ValidTag(tag), "tags", fmt.Sprintf("%q is not a valid tag", tag))
+		}
+	case BulkReschedule:
+		v.Check(!op.NextReviewDate.IsZero(), "next_review_date", "must be provided")
+	}
+}
+
+// Bulk applies the operation inside a single transaction and reports the
+// outcome for every targeted id. Cards that are missing or that would end up
+// invalid are reported and skipped; the rest are committed together.
+func (c CardModel) Bulk(op *BulkOperation) ([]BulkResult, error) {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var rows *sql.Rows
+	if op.Filter != nil {
+		query := fmt.Sprintf(`
+			SELECT id, tags
+			FROM cards
+			WHERE deleted_at IS NULL
+			AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+			AND (%s)
+			ORDER BY id
+			LIMIT $3
+			FOR UPDATE`, tagFilterClause("$2"))
+		rows, err = tx.Query(query, op.Filter.Title, pq.Array(op.Filter.Tags), MaxBulkCards+1)
+	} else {
+		query := `
+			SELECT id, tags
+			FROM cards
+			WHERE id = ANY($1) AND deleted_at IS NULL
+			ORDER BY id
+			FOR UPDATE`
+		rows, err = tx.Query(query, pq.Array(op.IDs))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int64{}
+	tags := map[int64][]string{}
+	for rows.Next() {
+		var id int64
+		var cardTags []string
+		err := rows.Scan(&id, pq.Array(&cardTags))
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		tags[id] = cardTags
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) > MaxBulkCards {
+		return nil, ErrTooManyCards
+	}
+
+	outcomes := map[int64]BulkResult{}
+
+	switch op.Action {
+	case BulkAddTags, BulkRemoveTags:
+		for _, id := range ids {
+			newTags := applyTagChange(op.Action, tags[id], op.Tags)
+			if len(newTags) == len(tags[id]) {
+				outcomes[id] = BulkResult{ID: id, Status: BulkStatusOK}
+				continue
+			}
+
+			v := validator.New()
+			if ValidateTags(v, newTags); !v.Valid() {
+				outcomes[id] = BulkResult{ID: id, Status: BulkStatusInvalid, Error: v.Errors["tags"]}
+				continue
+			}
+			err = snapshotCards(tx, []int64{id})
+			if err != nil {
+				return nil, err
+			}
+			_, err = tx.Exec(`UPDATE cards SET tags = $1, version = version + 1 WHERE id = $2`, pq.Array(newTags), id)
+			if err != nil {
+				return nil, err
+			}
+			outcomes[id] = BulkResult{ID: id, Status: BulkStatusOK}
+		}
+
+	default:
+		var query string
+		var args []interface{}
+		switch op.Action {
+		case BulkReschedule, BulkResetProgress:
+			query = `UPDATE cards SET next_review_date = $2, version = version + 1 WHERE id = ANY($1)`
+			args = []interface{}{pq.Array(ids), op.NextReviewDate}
+		case BulkSuspend, BulkUnsuspend:
+			query = `UPDATE cards SET suspended = $2, version = version + 1 WHERE id = ANY($1)`
+			args = []interface{}{pq.Array(ids), op.Action == BulkSuspend}
+		case BulkDelete:
+			query = `UPDATE cards SET deleted_at = NOW() WHERE id = ANY($1)`
+			args = []interface{}{pq.Array(ids)}
+		}
+		if op.Action != BulkDelete {
+			err = snapshotCards(tx, ids)
+			if err != nil {
+				return nil, err
+			}
+		}
+		_, err = tx.Exec(query, args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			outcomes[id] = BulkResult{ID: id, Status: BulkStatusOK}
+		}
+	}
+
+	changed := []int64{}
+	for _, id := range ids {
+		if outcomes[id].Status == BulkStatusOK {
+			changed = append(changed, id)
+		}
+	}
+	event := EventCardUpdated
+	if op.Action == BulkDelete {
+		event = EventCardDeleted
+	}
+	err = recordEvents(tx, event, changed)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	// Report ids in the order they were requested, or in id order for filters.
+	if op.IDs != nil {
+		ids = op.IDs
+	}
+	results := []BulkResult{}
+	seen := map[int64]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		result, found := outcomes[id]
+		if !found {
+			result = BulkResult{ID: id, Status: BulkStatusNotFound}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func applyTagChange(action string, current, changed []string) []string {
+	tags := []string{}
+	switch action {
+	case BulkAddTags:
+		tags = append(tags, current...)
+		for _, tag := range changed {
+			if !validator.In(tag, tags...) {
+				tags = append(tags, tag)
+			}
+		}
+	case BulkRemoveTags:
+		for _, tag := range current {
+			if !validator.In(tag, changed...) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -15,14 +16,32 @@ import (
 type CodeSnippet map[string]interface{}
 
 type Card struct {
-	ID             int64        `json:"id"`
-	CreatedAt      time.Time    `json:"created_at"`
-	Title          string       `json:"title"`
-	Tags           []string     `json:"tags"`
-	Content        string       `json:"content"`
-	NextReviewDate time.Time    `json:"next_review_date"`
-	CodeSnippet    *CodeSnippet `json:"code_snippet"`
-	Description    string       `json:"description"`
+	ID             int64          `json:"id"`
+	CreatedAt      time.Time      `json:"created_at"`
+	Title          string         `json:"title"`
+	Tags           []string       `json:"tags"`
+	Content        string         `json:"content"`
+	NextReviewDate time.Time      `json:"next_review_date"`
+	CodeSnippet    *CodeSnippet   `json:"code_snippet"`
+	Description    string         `json:"description"`
+	Version        int32          `json:"version"`
+	Suspended      bool           `json:"suspended"`
+	Audio          *CardAudio     `json:"audio"`
+	Occlusion      *CardOcclusion `json:"occlusion"`
+	DeletedAt      *time.Time     `json:"deleted_at,omitempty"`
+}
+
+const (
+	AudioSideFront = "front"
+	AudioSideBack  = "back"
+)
+
+// CardAudio is an uploaded audio file, such as a pronunciation, that clients
+// play on one side of a card. DurationMS is read from the file on upload.
+type CardAudio struct {
+	URL        string `json:"url"`
+	Side       string `json:"side"`
+	DurationMS int64  `json:"duration_ms"`
 }
 
 type CardModel struct {
@@ -60,29 +79,142 @@ func (c *CodeSnippet) Scan(src interface{}) error {
 	return nil
 }
 
+func (a CardAudio) Value() (driver.Value, error) {
+	return json.Marshal(a)
+}
+
+func (a *CardAudio) Scan(src interface{}) error {
+	source, ok := src.([]byte)
+	if !ok {
+		return errors.New("type assertion .([]byte) failed")
+	}
+	return json.Unmarshal(source, a)
+}
+
 func ValidateCard(v *validator.Validator, card *Card) {
 	v.Check(card.Title != "", "title", "must be provided")
 	v.Check(card.Content != "", "content", "must be provided")
-	v.Check(card.Tags != nil, "tags", "must be provided")
-	v.Check(len(card.Tags) >= 1, "tags", "must contain at least 1 tag")
-	v.Check(len(card.Tags) <= 5, "tags", "must not contain more than 5 tags")
+	ValidateTags(v, card.Tags)
+	if card.Audio != nil {
+		v.Check(card.Audio.URL != "", "audio.url", "must be provided")
+		v.Check(validator.In(card.Audio.Side, AudioSideFront, AudioSideBack), "audio.side", "must be front or back")
+	}
+}
+
+// MaxCardTags caps how many tags a card can carry. Hierarchical tags make
+// each tag narrower, so cards tend to need several.
+const MaxCardTags = 20
+
+func ValidateTags(v *validator.Validator, tags []string) {
+	v.Check(tags != nil, "tags", "must be provided")
+	v.Check(len(tags) >= 1, "tags", "must contain at least 1 tag")
+	v.Check(len(tags) <= MaxCardTags, "tags", fmt.Sprintf("must not contain more than %d tags", MaxCardTags))
+	v.Check(validator.Unique(tags), "tags", "must not contain duplicate values")
+
+	for _, tag := range tags {
+		if !ValidTag(tag) {
+			v.AddError("tags", fmt.Sprintf("%q is not a valid tag", tag))
+		}
+	}
 }
 
 func (c CardModel) Insert(card *Card) error {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
-			INSERT INTO cards (title, content, tags, next_review_date, code_snippet, description)
-			VALUES ($1, $2, $3, $4, $5, $6)
-			RETURNING id, created_at
+			INSERT INTO cards (title, content, tags, next_review_date, code_snippet, description, suspended, audio, occlusion)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING id, created_at, version
 		`
-	args := []interface{}{card.Title, card.Content, pq.Array(card.Tags), card.NextReviewDate, card.CodeSnippet, card.Description}
-	return c.DB.QueryRow(query, args...).Scan(&card.ID, &card.CreatedAt)
+	args := []interface{}{card.Title, card.Content, pq.Array(card.Tags), card.NextReviewDate, card.CodeSnippet, card.Description, card.Suspended, card.Audio, card.Occlusion}
+	err = tx.QueryRow(query, args...).Scan(&card.ID, &card.CreatedAt, &card.Version)
+	if err != nil {
+		return err
+	}
+
+	err = linkAttachments(tx, card.ID, cardMediaKeys(card))
+	if err != nil {
+		return err
+	}
+	err = recordEvent(tx, EventCardCreated, card.ID, cardEventData(card))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// InsertMany inserts the cards in a single transaction using multi-row
+// inserts, filling in the generated fields of each card.
+func (c CardModel) InsertMany(cards []*Card) error {
+	// Stay well below PostgreSQL's limit of 65535 bind parameters.
+	const chunkSize = 500
+
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(cards); start += chunkSize {
+		chunk := cards[start:min(start+chunkSize, len(cards))]
+
+		values := make([]string, 0, len(chunk))
+		args := make([]interface{}, 0, len(chunk)*9)
+		for i, card := range chunk {
+			n := i * 9
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9))
+			args = append(args, card.Title, card.Content, pq.Array(card.Tags), card.NextReviewDate, card.CodeSnippet, card.Description, card.Suspended, card.Audio, card.Occlusion)
+		}
+		query := fmt.Sprintf(`
+			INSERT INTO cards (title, content, tags, next_review_date, code_snippet, description, suspended, audio, occlusion)
+			VALUES %s
+			RETURNING id, created_at, version
+		`, strings.Join(values, ", "))
+
+		rows, err := tx.Query(query, args...)
+		if err != nil {
+			return err
+		}
+		i := 0
+		for rows.Next() {
+			err := rows.Scan(&chunk[i].ID, &chunk[i].CreatedAt, &chunk[i].Version)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			i++
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	for _, card := range cards {
+		// New cards have no links yet, so most need no query at all.
+		if keys := cardMediaKeys(card); len(keys) > 0 {
+			err = linkAttachments(tx, card.ID, keys)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = recordCardEvents(tx, EventCardCreated, cards)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (c CardModel) Get(id int64) (*Card, error) {
 	query := `
-		SELECT id, content, title, tags, code_snippet, created_at, next_review_date, description
+		SELECT id, content, title, tags, code_snippet, created_at, next_review_date, description, version, suspended, audio, occlusion
 		FROM cards
-		WHERE id = $1
+		WHERE id = $1 AND deleted_at IS NULL
 	`
 	var card Card
 	var s sql.NullString
@@ -95,6 +227,10 @@ func (c CardModel) Get(id int64) (*Card, error) {
 		&card.CreatedAt,
 		&card.NextReviewDate,
 		&s,
+		&card.Version,
+		&card.Suspended,
+		&card.Audio,
+		&card.Occlusion,
 	)
 	if s.Valid {
 		card.Description = s.String
@@ -110,12 +246,58 @@ func (c CardModel) Get(id int64) (*Card, error) {
 	return &card, nil
 }
 
+// GetMany returns the live cards with the given ids, in no particular order.
+// Ids with no card, or whose card is in the trash, are left out.
+func (c CardModel) GetMany(ids []int64) ([]*Card, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM cards
+		WHERE id = ANY($1) AND deleted_at IS NULL
+	`, cardColumns(CardFieldSafeList))
+	rows, err := c.DB.Query(query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cards := []*Card{}
+	for rows.Next() {
+		var card Card
+		err := rows.Scan(card.scanTargets(CardFieldSafeList)...)
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, &card)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return cards, nil
+}
+
+// Update saves the card, snapshotting its previous state into card_revisions
+// in the same transaction. A change to next_review_date is also recorded as a
+// review.completed event.
 func (c CardModel) Update(card *Card) error {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = snapshotCard(tx, card.ID, card.Version)
+	if err != nil {
+		return err
+	}
+
 	query := `
+		WITH previous AS (
+			SELECT next_review_date FROM cards WHERE id = $10
+		)
 		UPDATE cards
-		SET title = $1, content = $2, tags = $3, code_snippet = $4, next_review_date = $5, description = $6
-		WHERE id = $7
-		RETURNING id
+		SET title = $1, content = $2, tags = $3, code_snippet = $4, next_review_date = $5, description = $6, suspended = $7, audio = $8, occlusion = $9, version = version + 1
+		WHERE id = $10 AND version = $11 AND deleted_at IS NULL
+		RETURNING version, (SELECT next_review_date FROM previous)
 	`
 	args := []interface{}{
 		card.Title,
@@ -124,30 +306,63 @@ func (c CardModel) Update(card *Card) error {
 		card.CodeSnippet,
 		card.NextReviewDate,
 		card.Description,
+		card.Suspended,
+		card.Audio,
+		card.Occlusion,
 		card.ID,
+		card.Version,
 	}
-	err := c.DB.QueryRow(query, args...).Scan(&card.ID)
+	var previousReviewDate time.Time
+	err = tx.QueryRow(query, args...).Scan(&card.Version, &previousReviewDate)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrRecordNotFound
+			return ErrEditConflict
 		default:
 			return err
 		}
 	}
-	return nil
+
+	err = linkAttachments(tx, card.ID, cardMediaKeys(card))
+	if err != nil {
+		return err
+	}
+	err = recordEvent(tx, EventCardUpdated, card.ID, cardEventData(card))
+	if err != nil {
+		return err
+	}
+	if !previousReviewDate.Equal(card.NextReviewDate) {
+		review := map[string]interface{}{
+			"card_id":              card.ID,
+			"previous_review_date": previousReviewDate,
+			"next_review_date":     card.NextReviewDate,
+		}
+		err = recordEvent(tx, EventReviewCompleted, card.ID, review)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-func (c CardModel) Delete(id int64) error {
-	if id < 1 {
-		return ErrRecordNotFound
+// Delete moves the card to the trash. Trashed cards are hidden from every
+// other read and are purged permanently by PurgeTrash. Like Update, it fails
+// with ErrEditConflict if the card has changed or been deleted since it was
+// read.
+func (c CardModel) Delete(card *Card) error {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
+
 	query := `
-		DELETE FROM cards
-		WHERE id = $1
+		UPDATE cards
+		SET deleted_at = NOW()
+		WHERE id = $1 AND version = $2 AND deleted_at IS NULL
 	`
 
-	result, err := c.DB.Exec(query, id)
+	result, err := tx.Exec(query, card.ID, card.Version)
 	if err != nil {
 		return err
 	}
@@ -156,44 +371,85 @@ func (c CardModel) Delete(id int64) error {
 		return err
 	}
 	if rowsAffected == 0 {
-		return ErrRecordNotFound
+		return ErrEditConflict
 	}
-	return nil
+
+	err = recordEvent(tx, EventCardDeleted, card.ID, map[string]interface{}{"card_id": card.ID})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (c CardModel) GetAll(title string, tags []string, filters Filters) ([]*Card, Metadata, error) {
+// GetAll lists cards using offset pagination, or keyset pagination when the
+// filters carry a cursor. The total count is exact, estimated from the query
+// plan, or skipped entirely depending on filters.Count.
+//
+// When fields is non-empty only those columns are read, plus the id and sort
+// column needed to build cursors.
+func (c CardModel) GetAll(title string, tags []string, filters Filters, fields []string) ([]*Card, Metadata, error) {
+	where := fmt.Sprintf(`deleted_at IS NULL
+		AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (%s)`, tagFilterClause("$2"))
+	args := []interface{}{title, pq.Array(tags)}
+
+	totalRecords := 0
+	var err error
+	switch filters.Count {
+	case CountNone:
+	case CountEstimate:
+		totalRecords, err = c.estimateCount(where, args)
+	default:
+		err = c.DB.QueryRow("SELECT count(*) FROM cards WHERE "+where, args...).Scan(&totalRecords)
+	}
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	direction := filters.sortDirection()
+	keyset := ""
+	offset := filters.offset()
+
+	var cur *cursor
+	if filters.Cursor != "" {
+		cur, err = decodeCursor(filters.Cursor)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		// Paging backwards walks the sort order in reverse from the cursor; the
+		// page is flipped back into the requested order once it is read.
+		if cur.Backward {
+			direction = reverseDirection(direction)
+		}
+		comparison := ">"
+		if direction == "DESC" {
+			comparison = "<"
+		}
+		keyset = fmt.Sprintf("AND (%s, id) %s ($3::%s, $4)", filters.sortColumn(), comparison, filters.sortColumnType())
+		args = append(args, cur.Value, cur.ID)
+		offset = 0
+	}
+
+	// Fetch one extra row to find out whether another page follows.
+	args = append(args, filters.limit()+1, offset)
+	selected := cardSelection(fields, "id", filters.sortColumn())
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, created_at, title, next_review_date, tags, content, code_snippet, description
+		SELECT %s
 		FROM cards
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-		AND (tags @> $2 or $2 = '{}')
-		ORDER BY %s %s, created_at DESC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
-	args := []interface{}{title, pq.Array(tags), filters.limit(), filters.offset()}
+		WHERE %s
+		%s
+		ORDER BY %s %s, id %s
+		LIMIT $%d OFFSET $%d`, cardColumns(selected), where, keyset, filters.sortColumn(), direction, direction, len(args)-1, len(args))
 	rows, err := c.DB.Query(query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
-	cards := []*Card{}
-	totalRecords := 0
+	defer rows.Close()
 
+	cards := []*Card{}
 	for rows.Next() {
 		var card Card
-		var s sql.NullString
-		err := rows.Scan(
-			&totalRecords,
-			&card.ID,
-			&card.CreatedAt,
-			&card.Title,
-			&card.NextReviewDate,
-			pq.Array(&card.Tags),
-			&card.Content,
-			&card.CodeSnippet,
-			&s,
-		)
-		if s.Valid {
-			card.Description = s.String
-		}
+		err := rows.Scan(card.scanTargets(selected)...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -202,40 +458,87 @@ func (c CardModel) GetAll(title string, tags []string, filters Filters) ([]*Card
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	hasMore := len(cards) > filters.limit()
+	if hasMore {
+		cards = cards[:filters.limit()]
+	}
+	backward := cur != nil && cur.Backward
+	if backward {
+		for i, j := 0, len(cards)-1; i < j; i, j = i+1, j-1 {
+			cards[i], cards[j] = cards[j], cards[i]
+		}
+	}
+
+	var metadata Metadata
+	switch {
+	case cur != nil:
+		metadata = Metadata{PageSize: filters.PageSize, TotalRecords: totalRecords}
+	case filters.Count == CountNone:
+		metadata = Metadata{CurrentPage: filters.Page, PageSize: filters.PageSize, FirstPage: 1}
+	default:
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	}
+	metadata.TotalRecordsEstimated = filters.Count == CountEstimate && metadata.TotalRecords > 0
+
+	if len(cards) > 0 {
+		hasNext := hasMore || backward
+		hasPrev := (backward && hasMore) || (!backward && (cur != nil || filters.Page > 1))
+		if hasNext {
+			metadata.NextCursor = filters.cursorFor(cards[len(cards)-1], false)
+		}
+		if hasPrev {
+			metadata.PrevCursor = filters.cursorFor(cards[0], true)
+		}
+	}
 	return cards, metadata, nil
 }
 
-func (c CardModel) GetReviewCards() ([]*Card, error) {
-	query := `
-		SELECT id, created_at, title, next_review_date, tags, content, code_snippet, description
+// estimateCount returns the planner's row estimate for the filtered cards,
+// which avoids scanning every matching row on large tables.
+func (c CardModel) estimateCount(where string, args []interface{}) (int, error) {
+	var plan []byte
+	err := c.DB.QueryRow("EXPLAIN (FORMAT JSON) SELECT id FROM cards WHERE "+where, args...).Scan(&plan)
+	if err != nil {
+		return 0, err
+	}
+	var explain []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	err = json.Unmarshal(plan, &explain)
+	if err != nil {
+		return 0, err
+	}
+	if len(explain) == 0 {
+		return 0, nil
+	}
+	return int(explain[0].Plan.Rows), nil
+}
+
+// GetReviewCards returns the cards due for review. When fields is non-empty
+// only those columns are read.
+func (c CardModel) GetReviewCards(fields []string) ([]*Card, error) {
+	selected := cardSelection(fields)
+	query := fmt.Sprintf(`
+		SELECT %s
 		FROM cards
-		WHERE next_review_date <= CURRENT_DATE
-	`
+		WHERE next_review_date <= CURRENT_DATE AND NOT suspended AND deleted_at IS NULL
+	`, cardColumns(selected))
 	rows, err := c.DB.Query(query)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
 	cards := []*Card{}
-	var s sql.NullString
 	for rows.Next() {
 		var card Card
-		err := rows.Scan(
-			&card.ID,
-			&card.CreatedAt,
-			&card.Title,
-			&card.NextReviewDate,
-			pq.Array(&card.Tags),
-			&card.Content,
-			&card.CodeSnippet,
-			&s,
-		)
+		err := rows.Scan(card.scanTargets(selected)...)
 		if err != nil {
 			return nil, err
 		}
-		if s.Valid {
-			card.Description = s.String
-		}
 		cards = append(cards, &card)
 	}
 	if err = rows.Err(); err != nil {
@@ -244,11 +547,24 @@ func (c CardModel) GetReviewCards() ([]*Card, error) {
 	return cards, nil
 }
 
+// CountDue returns how many cards GetReviewCards would return.
+func (c CardModel) CountDue() (int, error) {
+	query := `
+		SELECT count(*)
+		FROM cards
+		WHERE next_review_date <= CURRENT_DATE AND NOT suspended AND deleted_at IS NULL
+	`
+	var count int
+	err := c.DB.QueryRow(query).Scan(&count)
+	return count, err
+}
+
 func (c CardModel) GetRandomCard() (*Card, error) {
 	query := `
-		SELECT id, content, title, tags, code_snippet, created_at, next_review_date, description
+		SELECT id, content, title, tags, code_snippet, created_at, next_review_date, description, version, suspended, audio, occlusion
 		FROM cards
-		ORDER BY RANDOM() 
+		WHERE deleted_at IS NULL
+		ORDER BY RANDOM()
 		LIMIT 1
 	`
 	var card Card
@@ -262,6 +578,10 @@ func (c CardModel) GetRandomCard() (*Card, error) {
 		&card.CreatedAt,
 		&card.NextReviewDate,
 		&s,
+		&card.Version,
+		&card.Suspended,
+		&card.Audio,
+		&card.Occlusion,
 	)
 
 	if err != nil {
@@ -280,3 +600,136 @@ func (c CardModel) GetRandomCard() (*Card, error) {
 	return &card, nil
 
 }
+
+func (c CardModel) GetTrash(filters Filters) ([]*Card, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), id, created_at, title, next_review_date, tags, content, code_snippet, description, version, suspended, audio, occlusion, deleted_at
+		FROM cards
+		WHERE deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, id DESC
+		LIMIT $1 OFFSET $2`
+	rows, err := c.DB.Query(query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	cards := []*Card{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var card Card
+		var s sql.NullString
+		err := rows.Scan(
+			&totalRecords,
+			&card.ID,
+			&card.CreatedAt,
+			&card.Title,
+			&card.NextReviewDate,
+			pq.Array(&card.Tags),
+			&card.Content,
+			&card.CodeSnippet,
+			&s,
+			&card.Version,
+			&card.Suspended,
+			&card.Audio,
+			&card.Occlusion,
+			&card.DeletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		if s.Valid {
+			card.Description = s.String
+		}
+		cards = append(cards, &card)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return cards, metadata, nil
+}
+
+func (c CardModel) Restore(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE cards
+		SET deleted_at = NULL
+		WHERE id = $1 AND deleted_at IS NOT NULL
+	`
+
+	result, err := tx.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = recordEvents(tx, EventCardUpdated, []int64{id})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeTrash permanently deletes cards that were trashed before the cutoff
+// and returns how many were removed. Attachments that were linked only to
+// those cards restart their retention period, as they are now unused;
+// attachments still linked from another card are left alone.
+func (c CardModel) PurgeTrash(cutoff time.Time) (int64, error) {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE attachments
+		SET unlinked_at = NOW()
+		WHERE id IN (
+			SELECT ca.attachment_id
+			FROM card_attachments ca
+			JOIN cards c ON c.id = ca.card_id
+			WHERE c.deleted_at < $1
+		)
+		AND NOT EXISTS (
+			SELECT 1
+			FROM card_attachments ca
+			JOIN cards c ON c.id = ca.card_id
+			WHERE ca.attachment_id = attachments.id
+			AND (c.deleted_at IS NULL OR c.deleted_at >= $1)
+		)
+	`
+	_, err = tx.Exec(query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `
+		DELETE FROM cards
+		WHERE deleted_at < $1
+	`
+	result, err := tx.Exec(query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return purged, tx.Commit()
+}
@@ -0,0 +1,242 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// EventsChannel is the PostgreSQL NOTIFY channel that carries the id of every
+// event appended to card_events.
+const EventsChannel = "card_events"
+
+// Event is an entry in the card event log. Data holds the card for created
+// and updated events when it is available, and at least its id otherwise.
+type Event struct {
+	ID        int64           `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Type      string          `json:"type"`
+	CardID    int64           `json:"card_id"`
+	Data      json.RawMessage `json:"data"`
+	TxID      int64           `json:"-"`
+}
+
+func (e *Event) Position() LogPosition {
+	return LogPosition{TxID: e.TxID, ID: e.ID}
+}
+
+// LogPosition is an entry's place in an append-only log such as the card
+// event log or the review log. Ids come from a sequence before the
+// transaction that adds the entry commits, so transactions that commit out of
+// order make ids appear out of order too, and a reader paging by id would
+// skip the late ones for good. Entries are instead read in the order of the
+// transaction that added them (TxID, its PostgreSQL transaction id), and only
+// once every transaction that could still add an earlier one has finished.
+// Event stream clients only ever see the event id, which is turned back into
+// a position with EventModel.Position.
+type LogPosition struct {
+	TxID int64
+	ID   int64
+}
+
+// Before reports whether p comes before q in the log.
+func (p LogPosition) Before(q LogPosition) bool {
+	return p.TxID < q.TxID || (p.TxID == q.TxID && p.ID < q.ID)
+}
+
+// logHorizon is the id of the oldest transaction still running. Log entries
+// added by transactions below it are settled: nothing can commit before them
+// any more. Entries at or above it are held back until it moves past.
+const logHorizon = `pg_snapshot_xmin(pg_current_snapshot())::text::bigint`
+
+type EventModel struct {
+	DB *sql.DB
+}
+
+// recordEvent appends an event to the log, queues a delivery of it to every
+// active webhook subscribed to its type, and notifies listeners. All of this
+// happens in the caller's transaction, so nothing is sent for a change that
+// rolls back and nothing is lost for one that commits.
+func recordEvent(tx *sql.Tx, eventType string, cardID int64, data interface{}) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	query := `
+		WITH event AS (
+			INSERT INTO card_events (type, card_id, data)
+			VALUES ($1, $2, $3)
+			RETURNING id, type, data
+		), delivery AS (
+			INSERT INTO webhook_deliveries (webhook_id, event, payload)
+			SELECT w.id, event.type, event.data
+			FROM webhooks w, event
+			WHERE w.active AND event.type = ANY(w.events)
+		)
+		SELECT pg_notify($4, id::text) FROM event
+	`
+	_, err = tx.Exec(query, eventType, cardID, js, EventsChannel)
+	return err
+}
+
+// recordEvents appends and queues one event per card id, with only the id as
+// data, like recordEvent. It is used by bulk changes, where the cards
+// themselves are not loaded.
+func recordEvents(tx *sql.Tx, eventType string, cardIDs []int64) error {
+	if len(cardIDs) == 0 {
+		return nil
+	}
+	query := `
+		WITH event AS (
+			INSERT INTO card_events (type, card_id, data)
+			SELECT $1::text, id, jsonb_build_object('card_id', id)
+			FROM unnest($2::bigint[]) AS id
+			RETURNING id, type, data
+		), delivery AS (
+			INSERT INTO webhook_deliveries (webhook_id, event, payload)
+			SELECT w.id, event.type, event.data
+			FROM webhooks w, event
+			WHERE w.active AND event.type = ANY(w.events)
+			ORDER BY event.id
+		)
+		SELECT pg_notify($3, max(id)::text) FROM event
+	`
+	_, err := tx.Exec(query, eventType, pq.Array(cardIDs), EventsChannel)
+	return err
+}
+
+// recordCardEvents appends and queues one event per card, with the card as
+// data, like recordEvent but in a single statement. It is used when cards are
+// created in bulk.
+func recordCardEvents(tx *sql.Tx, eventType string, cards []*Card) error {
+	if len(cards) == 0 {
+		return nil
+	}
+	cardIDs := make([]int64, len(cards))
+	data := make([]string, len(cards))
+	for i, card := range cards {
+		js, err := json.Marshal(cardEventData(card))
+		if err != nil {
+			return err
+		}
+		cardIDs[i] = card.ID
+		data[i] = string(js)
+	}
+	query := `
+		WITH event AS (
+			INSERT INTO card_events (type, card_id, data)
+			SELECT $1::text, e.card_id, e.data::jsonb
+			FROM unnest($2::bigint[], $3::text[]) WITH ORDINALITY AS e(card_id, data, n)
+			ORDER BY e.n
+			RETURNING id, type, data
+		), delivery AS (
+			INSERT INTO webhook_deliveries (webhook_id, event, payload)
+			SELECT w.id, event.type, event.data
+			FROM webhooks w, event
+			WHERE w.active AND event.type = ANY(w.events)
+			ORDER BY event.id
+		)
+		SELECT pg_notify($4, max(id)::text) FROM event
+	`
+	_, err := tx.Exec(query, eventType, pq.Array(cardIDs), pq.Array(data), EventsChannel)
+	return err
+}
+
+func cardEventData(card *Card) map[string]interface{} {
+	return map[string]interface{}{"card_id": card.ID, "card": card}
+}
+
+// Position returns the position of the event with the given id, or the
+// start of the log for id 0. It fails with ErrRecordNotFound if the event
+// doesn't exist, which includes events pruned from the log.
+func (m EventModel) Position(id int64) (LogPosition, error) {
+	if id == 0 {
+		return LogPosition{}, nil
+	}
+	pos := LogPosition{ID: id}
+	err := m.DB.QueryRow(`SELECT txid FROM card_events WHERE id = $1`, id).Scan(&pos.TxID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return LogPosition{}, ErrRecordNotFound
+		default:
+			return LogPosition{}, err
+		}
+	}
+	return pos, nil
+}
+
+// GetSince returns up to limit settled events after the position, in log
+// order.
+func (m EventModel) GetSince(after LogPosition, limit int) ([]*Event, error) {
+	query := fmt.Sprintf(`
+		SELECT id, created_at, type, card_id, data, txid
+		FROM card_events
+		WHERE (txid, id) > ($1, $2) AND txid < %s
+		ORDER BY txid, id
+		LIMIT $3
+	`, logHorizon)
+	rows, err := m.DB.Query(query, after.TxID, after.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*Event{}
+	for rows.Next() {
+		var event Event
+		err := rows.Scan(&event.ID, &event.CreatedAt, &event.Type, &event.CardID, &event.Data, &event.TxID)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Latest returns the position of the newest settled event, or the start of
+// the log if there is none. Everything logged after it, including events
+// held back now, comes after it.
+func (m EventModel) Latest() (LogPosition, error) {
+	query := fmt.Sprintf(`
+		SELECT txid, id
+		FROM card_events
+		WHERE txid < %s
+		ORDER BY txid DESC, id DESC
+		LIMIT 1
+	`, logHorizon)
+	var pos LogPosition
+	err := m.DB.QueryRow(query).Scan(&pos.TxID, &pos.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return LogPosition{}, nil
+	}
+	return pos, err
+}
+
+// HasHeldBack reports whether any committed events are being held back
+// because an older transaction is still running. No notification is sent
+// when that transaction ends without logging anything, so readers poll
+// while this is true.
+func (m EventModel) HasHeldBack() (bool, error) {
+	query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM card_events WHERE txid >= %s)`, logHorizon)
+	var held bool
+	err := m.DB.QueryRow(query).Scan(&held)
+	return held, err
+}
+
+// DeleteBefore prunes events older than the cutoff and returns how many were
+// removed.
+func (m EventModel) DeleteBefore(cutoff time.Time) (int64, error) {
+	result, err := m.DB.Exec(`DELETE FROM card_events WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
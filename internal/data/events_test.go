@@ -0,0 +1,209 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// openTestDB connects to the database named by CSFLASHCARDS_TEST_DB_DSN,
+// which must have the migrations applied. Tests that need it are skipped
+// without it.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("CSFLASHCARDS_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("CSFLASHCARDS_TEST_DB_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err = db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+const testEventType = "test.event"
+
+// beginTx starts a transaction that is rolled back at the end of the test if
+// it hasn't been committed. If early is set it is given a transaction id
+// straight away, rather than when it first writes.
+func beginTx(t *testing.T, db *sql.DB, early bool) *sql.Tx {
+	t.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+	if early {
+		_, err = tx.Exec(`SELECT pg_current_xact_id()`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tx
+}
+
+// logTestEvent logs an event in the transaction and returns its id.
+func logTestEvent(t *testing.T, tx *sql.Tx) int64 {
+	t.Helper()
+	err := recordEvent(tx, testEventType, 0, map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id int64
+	err = tx.QueryRow(`SELECT currval('card_events_id_seq')`).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// readTestEvents pages through the log after the position, as the event
+// listener does, and returns the ids of the test events it finds.
+func readTestEvents(t *testing.T, m EventModel, after *LogPosition) []int64 {
+	t.Helper()
+	ids := []int64{}
+	for {
+		events, err := m.GetSince(*after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range events {
+			if event.Type == testEventType {
+				ids = append(ids, event.ID)
+			}
+			*after = event.Position()
+		}
+		if len(events) < 2 {
+			return ids
+		}
+	}
+}
+
+func commit(t *testing.T, tx *sql.Tx) {
+	t.Helper()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestEventsInterleavedCommits checks that events committed out of id order
+// are all read exactly once, in the order their transactions settle.
+func TestEventsInterleavedCommits(t *testing.T) {
+	db := openTestDB(t)
+	m := EventModel{DB: db}
+	t.Cleanup(func() { db.Exec(`DELETE FROM card_events WHERE type = $1`, testEventType) })
+
+	t.Run("later transaction commits first", func(t *testing.T) {
+		after, err := m.Latest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		txA := beginTx(t, db, false)
+		idA := logTestEvent(t, txA)
+		txB := beginTx(t, db, false)
+		idB := logTestEvent(t, txB)
+		commit(t, txB)
+
+		// B's event is held back while A, which is older, could still commit.
+		if got := readTestEvents(t, m, &after); len(got) != 0 {
+			t.Fatalf("read %v while an older transaction was open; want nothing", got)
+		}
+		held, err := m.HasHeldBack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !held {
+			t.Error("HasHeldBack is false with a committed event behind an open transaction")
+		}
+
+		commit(t, txA)
+		got := readTestEvents(t, m, &after)
+		if len(got) != 2 || got[0] != idA || got[1] != idB {
+			t.Errorf("read %v; want [%d %d]", got, idA, idB)
+		}
+	})
+
+	t.Run("older transaction takes the later id", func(t *testing.T) {
+		after, err := m.Latest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// B gets its transaction id first but logs its event after A, so its
+		// event id is higher even though it settles first.
+		txB := beginTx(t, db, true)
+		txA := beginTx(t, db, true)
+		idA := logTestEvent(t, txA)
+		idB := logTestEvent(t, txB)
+		commit(t, txB)
+
+		got := readTestEvents(t, m, &after)
+		if len(got) != 1 || got[0] != idB {
+			t.Fatalf("read %v after the first commit; want [%d]", got, idB)
+		}
+
+		// Paging by id would skip A's event now, as it is below B's.
+		commit(t, txA)
+		got = readTestEvents(t, m, &after)
+		if len(got) != 1 || got[0] != idA {
+			t.Errorf("read %v after the second commit; want [%d]", got, idA)
+		}
+	})
+}
+
+// TestInsertManyEvents checks that bulk-created cards are each logged once,
+// in order, with the card as data.
+func TestInsertManyEvents(t *testing.T) {
+	db := openTestDB(t)
+	events := EventModel{DB: db}
+	after, err := events.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cards := []*Card{
+		{Title: "first", Content: "a", Tags: []string{"test"}, NextReviewDate: time.Now()},
+		{Title: "second", Content: "b", Tags: []string{"test"}, NextReviewDate: time.Now()},
+	}
+	err = CardModel{DB: db}.InsertMany(cards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM card_events WHERE card_id = ANY($1)`, pq.Array([]int64{cards[0].ID, cards[1].ID}))
+		db.Exec(`DELETE FROM cards WHERE id = ANY($1)`, pq.Array([]int64{cards[0].ID, cards[1].ID}))
+	})
+
+	logged, err := events.GetSince(after, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []*Event{}
+	for _, event := range logged {
+		if event.Type == EventCardCreated && (event.CardID == cards[0].ID || event.CardID == cards[1].ID) {
+			got = append(got, event)
+		}
+	}
+	if len(got) != 2 || got[0].CardID != cards[0].ID || got[1].CardID != cards[1].ID {
+		t.Fatalf("logged %d created events in the wrong order or count", len(got))
+	}
+	for i, event := range got {
+		var data struct {
+			Card Card `json:"card"`
+		}
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		if data.Card.Title != cards[i].Title {
+			t.Errorf("event for card %d has title %q; want %q", cards[i].ID, data.Card.Title, cards[i].Title)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/vynquoc/cs-flash-cards/internal/validator"
+)
+
+// CardFieldSafeList holds the card fields that clients may request with the
+// fields query string parameter.
+var CardFieldSafeList = []string{
+	"id",
+	"created_at",
+	"title",
+	"tags",
+	"content",
+	"next_review_date",
+	"code_snippet",
+	"description",
+	"version",
+	"suspended",
+	"audio",
+	"occlusion",
+}
+
+func ValidateFields(v *validator.Validator, fields []string) {
+	for _, field := range fields {
+		v.Check(validator.In(field, CardFieldSafeList...), "fields", fmt.Sprintf("%q is not a valid field", field))
+	}
+}
+
+// cardSelection returns the fields to select: every field when none were
+// requested, otherwise the requested fields plus any the query itself needs.
+func cardSelection(fields []string, required ...string) []string {
+	if len(fields) == 0 {
+		return CardFieldSafeList
+	}
+	selected := []string{}
+	for _, field := range append(required, fields...) {
+		if !validator.In(field, selected...) {
+			selected = append(selected, field)
+		}
+	}
+	return selected
+}
+
+func cardColumns(fields []string) string {
+	columns := make([]string, len(fields))
+	for i, field := range fields {
+		switch field {
+		case "description":
+			columns[i] = "coalesce(description, '')"
+		default:
+			columns[i] = field
+		}
+	}
+	return strings.Join(columns, ", ")
+}
+
+func (card *Card) scanTargets(fields []string) []interface{} {
+	targets := make([]interface{}, len(fields))
+	for i, field := range fields {
+		switch field {
+		case "id":
+			targets[i] = &card.ID
+		case "created_at":
+			targets[i] = &card.CreatedAt
+		case "title":
+			targets[i] = &card.Title
+		case "tags":
+			targets[i] = pq.Array(&card.Tags)
+		case "content":
+			targets[i] = &card.Content
+		case "next_review_date":
+			targets[i] = &card.NextReviewDate
+		case "code_snippet":
+			targets[i] = &card.CodeSnippet
+		case "description":
+			targets[i] = &card.Description
+		case "version":
+			targets[i] = &card.Version
+		case "suspended":
+			targets[i] = &card.Suspended
+		case "audio":
+			targets[i] = &card.Audio
+		case "occlusion":
+			targets[i] = &card.Occlusion
+		}
+	}
+	return targets
+}
+
+// Project returns only the requested fields of the card, keyed by their JSON
+// names.
+func (card *Card) Project(fields []string) map[string]interface{} {
+	projection := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		switch field {
+		case "id":
+			projection[field] = card.ID
+		case "created_at":
+			projection[field] = card.CreatedAt
+		case "title":
+			projection[field] = card.Title
+		case "tags":
+			projection[field] = card.Tags
+		case "content":
+			projection[field] = card.Content
+		case "next_review_date":
+			projection[field] = card.NextReviewDate
+		case "code_snippet":
+			projection[field] = card.CodeSnippet
+		case "description":
+			projection[field] = card.Description
+		case "version":
+			projection[field] = card.Version
+		case "suspended":
+			projection[field] = card.Suspended
+		case "audio":
+			projection[field] = card.Audio
+		case "occlusion":
+			projection[field] = card.Occlusion
+		}
+	}
+	return projection
+}
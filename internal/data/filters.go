@@ -1,24 +1,104 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"math"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/vynquoc/cs-flash-cards/internal/validator"
 )
 
+const (
+	CountExact    = "exact"
+	CountEstimate = "estimate"
+	CountNone     = "none"
+)
+
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+	Cursor       string
+	Count        string
 }
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage           int    `json:"current_page,omitempty"`
+	PageSize              int    `json:"page_size,omitempty"`
+	FirstPage             int    `json:"first_page,omitempty"`
+	LastPage              int    `json:"last_page,omitempty"`
+	TotalRecords          int    `json:"total_records,omitempty"`
+	TotalRecordsEstimated bool   `json:"total_records_estimated,omitempty"`
+	NextCursor            string `json:"next_cursor,omitempty"`
+	PrevCursor            string `json:"prev_cursor,omitempty"`
+}
+
+// cursor is the decoded form of the opaque keyset pagination token. It holds
+// the sort key and id of the row to continue from, and whether to page
+// backwards from it.
+type cursor struct {
+	Sort     string `json:"s"`
+	Value    string `json:"v"`
+	ID       int64  `json:"i"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func (c cursor) encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var c cursor
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.ID < 1 {
+		return nil, errInvalidCursor
+	}
+	return &c, nil
+}
+
+// cursorFor builds the cursor pointing at the given card for the current sort.
+func (f Filters) cursorFor(card *Card, backward bool) string {
+	c := cursor{Sort: f.Sort, ID: card.ID, Backward: backward}
+	switch f.sortColumn() {
+	case "id":
+		c.Value = strconv.FormatInt(card.ID, 10)
+	case "title":
+		c.Value = card.Title
+	case "created_at":
+		c.Value = card.CreatedAt.Format(time.DateOnly)
+	case "next_review_date":
+		c.Value = card.NextReviewDate.Format(time.DateOnly)
+	}
+	return c.encode()
+}
+
+// CursorFor returns the cursor that continues the listing after the card.
+func (f Filters) CursorFor(card *Card) string {
+	return f.cursorFor(card, false)
+}
+
+// sortColumnType is the PostgreSQL type the cursor value is cast to when it is
+// compared against the sort column.
+func (f Filters) sortColumnType() string {
+	switch f.sortColumn() {
+	case "id":
+		return "bigint"
+	case "created_at", "next_review_date":
+		return "date"
+	default:
+		return "text"
+	}
 }
 
 func (f Filters) limit() int {
@@ -45,6 +125,13 @@ func (f Filters) sortDirection() string {
 	return "ASC"
 }
 
+func reverseDirection(direction string) string {
+	if direction == "DESC" {
+		return "ASC"
+	}
+	return "DESC"
+}
+
 func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.Page > 0, "page", "must be greater than 0")
 	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
@@ -52,6 +139,17 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
 	v.Check(validator.In(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+	v.Check(f.Count == "" || validator.In(f.Count, CountExact, CountEstimate, CountNone), "count", "invalid count value")
+
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			v.AddError("cursor", "invalid cursor value")
+			return
+		}
+		v.Check(c.Sort == f.Sort, "cursor", "does not match the sort parameter")
+		v.Check(f.Page == 1, "page", "must not be combined with a cursor")
+	}
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
@@ -0,0 +1,171 @@
+package data
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrIdempotencyKeyMismatch   = errors.New("idempotency key reused with a different request")
+	ErrIdempotencyKeyInProgress = errors.New("idempotency key in progress")
+	ErrIdempotencyLockLost      = errors.New("idempotency key lock lost")
+)
+
+// StoredResponse is the response recorded for an idempotency key, replayed
+// verbatim when the same request is retried.
+type StoredResponse struct {
+	Status  int
+	Headers http.Header
+	Body    []byte
+}
+
+type IdempotencyModel struct {
+	DB *sql.DB
+}
+
+// IdempotencyLock is a request's claim on an idempotency key. It lapses at
+// LockedUntil unless extended, after which a retry of the same request can
+// take the key over. That way a key whose request died with the server
+// doesn't stay in progress until it expires.
+type IdempotencyLock struct {
+	Key         string
+	LockedUntil time.Time
+}
+
+// Reserve claims the key for a new request, locking it for the lease. It
+// returns a lock when the caller should go on to process the request, or the
+// stored response if the same request has already completed.
+func (m IdempotencyModel) Reserve(key string, requestHash []byte, ttl, lease time.Duration) (*IdempotencyLock, *StoredResponse, error) {
+	// Postgres keeps microseconds, and the lock is matched on the exact time.
+	now := time.Now()
+	lock := &IdempotencyLock{Key: key, LockedUntil: now.Add(lease).Truncate(time.Microsecond)}
+
+	// An expired key is reused, as is a lapsed lock held for the same request.
+	query := `
+		INSERT INTO idempotency_keys (key, request_hash, expires_at, locked_until)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status = NULL, headers = NULL, body = NULL,
+			created_at = NOW(), expires_at = EXCLUDED.expires_at, locked_until = EXCLUDED.locked_until
+		WHERE idempotency_keys.expires_at <= NOW()
+			OR (idempotency_keys.status IS NULL
+				AND idempotency_keys.locked_until <= NOW()
+				AND idempotency_keys.request_hash = EXCLUDED.request_hash)
+	`
+	result, err := m.DB.Exec(query, key, requestHash, now.Add(ttl), lock.LockedUntil)
+	if err != nil {
+		return nil, nil, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, nil, err
+	}
+	if rowsAffected == 1 {
+		return lock, nil, nil
+	}
+
+	query = `
+		SELECT request_hash, status, headers, body
+		FROM idempotency_keys
+		WHERE key = $1
+	`
+	var storedHash []byte
+	var status sql.NullInt32
+	var headers []byte
+	var response StoredResponse
+	err = m.DB.QueryRow(query, key).Scan(&storedHash, &status, &headers, &response.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// The key was released between the insert and the select.
+			return nil, nil, ErrIdempotencyKeyInProgress
+		default:
+			return nil, nil, err
+		}
+	}
+	if !bytes.Equal(storedHash, requestHash) {
+		return nil, nil, ErrIdempotencyKeyMismatch
+	}
+	if !status.Valid {
+		return nil, nil, ErrIdempotencyKeyInProgress
+	}
+	response.Status = int(status.Int32)
+	if headers != nil {
+		err = json.Unmarshal(headers, &response.Headers)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return nil, &response, nil
+}
+
+// Extend renews the lock for another lease. It fails with
+// ErrIdempotencyLockLost if the lock lapsed and another request took the key
+// over.
+func (m IdempotencyModel) Extend(lock *IdempotencyLock, lease time.Duration) error {
+	lockedUntil := time.Now().Add(lease).Truncate(time.Microsecond)
+	query := `
+		UPDATE idempotency_keys
+		SET locked_until = $1
+		WHERE key = $2 AND locked_until = $3 AND status IS NULL
+	`
+	err := m.execLocked(query, lockedUntil, lock.Key, lock.LockedUntil)
+	if err != nil {
+		return err
+	}
+	lock.LockedUntil = lockedUntil
+	return nil
+}
+
+// Complete stores the response for a locked key. It fails with
+// ErrIdempotencyLockLost, storing nothing, if the lock was lost.
+func (m IdempotencyModel) Complete(lock *IdempotencyLock, response *StoredResponse) error {
+	headers, err := json.Marshal(response.Headers)
+	if err != nil {
+		return err
+	}
+	query := `
+		UPDATE idempotency_keys
+		SET status = $1, headers = $2, body = $3
+		WHERE key = $4 AND locked_until = $5 AND status IS NULL
+	`
+	return m.execLocked(query, response.Status, headers, response.Body, lock.Key, lock.LockedUntil)
+}
+
+// Release frees a locked key whose request failed, so that it can be retried
+// straight away. A lost lock is left to the request that took it over.
+func (m IdempotencyModel) Release(lock *IdempotencyLock) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE key = $1 AND locked_until = $2 AND status IS NULL
+	`
+	_, err := m.DB.Exec(query, lock.Key, lock.LockedUntil)
+	return err
+}
+
+func (m IdempotencyModel) execLocked(query string, args ...interface{}) error {
+	result, err := m.DB.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrIdempotencyLockLost
+	}
+	return nil
+}
+
+func (m IdempotencyModel) DeleteExpired() (int64, error) {
+	result, err := m.DB.Exec(`DELETE FROM idempotency_keys WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,62 @@
+package data
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyLockTakeover(t *testing.T) {
+	db := openTestDB(t)
+	m := IdempotencyModel{DB: db}
+	key := "test-" + time.Now().Format(time.RFC3339Nano)
+	t.Cleanup(func() { db.Exec(`DELETE FROM idempotency_keys WHERE key = $1`, key) })
+	hash := []byte("request")
+
+	first, _, err := m.Reserve(key, hash, time.Hour, time.Second)
+	if err != nil || first == nil {
+		t.Fatalf("first Reserve = %v, %v; want a lock", first, err)
+	}
+
+	// The lock is held until it lapses.
+	_, _, err = m.Reserve(key, hash, time.Hour, time.Second)
+	if !errors.Is(err, ErrIdempotencyKeyInProgress) {
+		t.Fatalf("Reserve while locked = %v; want ErrIdempotencyKeyInProgress", err)
+	}
+	_, _, err = m.Reserve(key, []byte("other request"), time.Hour, time.Second)
+	if !errors.Is(err, ErrIdempotencyKeyMismatch) {
+		t.Fatalf("Reserve for another request = %v; want ErrIdempotencyKeyMismatch", err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	_, _, err = m.Reserve(key, []byte("other request"), time.Hour, time.Second)
+	if !errors.Is(err, ErrIdempotencyKeyMismatch) {
+		t.Fatalf("Reserve for another request after the lock lapsed = %v; want ErrIdempotencyKeyMismatch", err)
+	}
+	second, _, err := m.Reserve(key, hash, time.Hour, time.Minute)
+	if err != nil || second == nil {
+		t.Fatalf("Reserve after the lock lapsed = %v, %v; want a lock", second, err)
+	}
+
+	// The first request can no longer store or release anything.
+	err = m.Complete(first, &StoredResponse{Status: http.StatusCreated})
+	if !errors.Is(err, ErrIdempotencyLockLost) {
+		t.Fatalf("Complete with the lapsed lock = %v; want ErrIdempotencyLockLost", err)
+	}
+	if err = m.Release(first); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = m.Extend(second, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	err = m.Complete(second, &StoredResponse{Status: http.StatusCreated, Body: []byte("{}")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, stored, err := m.Reserve(key, hash, time.Hour, time.Minute)
+	if err != nil || stored == nil || stored.Status != http.StatusCreated {
+		t.Fatalf("Reserve after Complete = %+v, %v; want the stored response", stored, err)
+	}
+}
@@ -7,14 +7,33 @@ import (
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
+	ErrEditConflict   = errors.New("edit conflict")
 )
 
 type Models struct {
-	Cards CardModel
+	Attachments     AttachmentModel
+	Cards           CardModel
+	Events          EventModel
+	IdempotencyKeys IdempotencyModel
+	Revisions       RevisionModel
+	Reviews         ReviewModel
+	Sync            SyncModel
+	Tags            TagModel
+	Uploads         UploadModel
+	Webhooks        WebhookModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Cards: CardModel{DB: db},
+		Attachments:     AttachmentModel{DB: db},
+		Cards:           CardModel{DB: db},
+		Events:          EventModel{DB: db},
+		IdempotencyKeys: IdempotencyModel{DB: db},
+		Revisions:       RevisionModel{DB: db},
+		Reviews:         ReviewModel{DB: db},
+		Sync:            SyncModel{DB: db},
+		Tags:            TagModel{DB: db},
+		Uploads:         UploadModel{DB: db},
+		Webhooks:        WebhookModel{DB: db},
 	}
 }
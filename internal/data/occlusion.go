@@ -0,0 +1,92 @@
+package data
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/vynquoc/cs-flash-cards/internal/validator"
+)
+
+const (
+	// OcclusionHideAll keeps every mask on the question side, so only the
+	// position of the hidden region gives it away.
+	OcclusionHideAll = "hide_all"
+	// OcclusionHideOne masks only the region being asked about.
+	OcclusionHideOne = "hide_one"
+)
+
+// MaxOcclusionMasks caps how many masks, and so cards, one image can have.
+const MaxOcclusionMasks = 100
+
+// OcclusionMask is a rectangle over an image. Coordinates are fractions of
+// the image's width and height, so masks don't depend on the size the image
+// is displayed at.
+type OcclusionMask struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+	Label  string  `json:"label,omitempty"`
+}
+
+// CardOcclusion makes a card an image occlusion card: it asks about the mask
+// at index Target of Masks. Question and Answer are the masks to draw over
+// the image on each side of the card.
+type CardOcclusion struct {
+	ImageURL string          `json:"image_url"`
+	Mode     string          `json:"mode"`
+	Target   int             `json:"target"`
+	Masks    []OcclusionMask `json:"masks"`
+	Question []OcclusionMask `json:"question"`
+	Answer   []OcclusionMask `json:"answer"`
+}
+
+// NewCardOcclusion returns the occlusion for the card that asks about the
+// mask at index target.
+func NewCardOcclusion(imageURL, mode string, masks []OcclusionMask, target int) *CardOcclusion {
+	occlusion := &CardOcclusion{
+		ImageURL: imageURL,
+		Mode:     mode,
+		Target:   target,
+		Masks:    masks,
+		Question: []OcclusionMask{masks[target]},
+		Answer:   []OcclusionMask{},
+	}
+	if mode == OcclusionHideAll {
+		occlusion.Question = masks
+		for i, mask := range masks {
+			if i != target {
+				occlusion.Answer = append(occlusion.Answer, mask)
+			}
+		}
+	}
+	return occlusion
+}
+
+func (o CardOcclusion) Value() (driver.Value, error) {
+	return json.Marshal(o)
+}
+
+func (o *CardOcclusion) Scan(src interface{}) error {
+	source, ok := src.([]byte)
+	if !ok {
+		return errors.New("type assertion .([]byte) failed")
+	}
+	return json.Unmarshal(source, o)
+}
+
+func ValidateOcclusionMasks(v *validator.Validator, mode string, masks []OcclusionMask) {
+	v.Check(validator.In(mode, OcclusionHideAll, OcclusionHideOne), "mode", "must be hide_all or hide_one")
+	v.Check(len(masks) >= 1, "masks", "must contain at least 1 mask")
+	v.Check(len(masks) <= MaxOcclusionMasks, "masks", fmt.Sprintf("must not contain more than %d masks", MaxOcclusionMasks))
+
+	for i, mask := range masks {
+		key := fmt.Sprintf("masks[%d]", i)
+		v.Check(mask.X >= 0 && mask.Y >= 0, key, "must not start outside the image")
+		v.Check(mask.Width > 0 && mask.Height > 0, key, "must have a positive width and height")
+		v.Check(mask.X+mask.Width <= 1 && mask.Y+mask.Height <= 1, key, "must not extend past the image; coordinates are fractions of its size")
+		v.Check(len(mask.Label) <= 500, key, "must not have a label longer than 500 bytes")
+	}
+}
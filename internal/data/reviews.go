@@ -0,0 +1,216 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/vynquoc/cs-flash-cards/internal/validator"
+)
+
+// Review is an entry in the review log. ClientID is chosen by the client so
+// that a review uploaded twice, e.g. after a dropped connection, is only
+// logged once.
+type Review struct {
+	ID             int64     `json:"id"`
+	ClientID       string    `json:"client_id"`
+	CardID         int64     `json:"card_id"`
+	ReviewedAt     time.Time `json:"reviewed_at"`
+	Grade          *int      `json:"grade"`
+	NextReviewDate time.Time `json:"next_review_date"`
+	TxID           int64     `json:"-"`
+}
+
+func (r *Review) Position() LogPosition {
+	return LogPosition{TxID: r.TxID, ID: r.ID}
+}
+
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.ClientID != "", "client_id", "must be provided")
+	v.Check(len(review.ClientID) <= 255, "client_id", "must not be more than 255 bytes long")
+	v.Check(review.CardID > 0, "card_id", "must be a positive integer")
+	v.Check(!review.ReviewedAt.IsZero(), "reviewed_at", "must be provided")
+	v.Check(review.ReviewedAt.Before(time.Now().Add(time.Hour)), "reviewed_at", "must not be in the future")
+	v.Check(!review.NextReviewDate.IsZero(), "next_review_date", "must be provided")
+	if review.Grade != nil {
+		v.Check(*review.Grade >= 0 && *review.Grade <= 5, "grade", "must be between 0 and 5")
+	}
+}
+
+// Merge adds the review to the log. Reviews merge rather than conflict: the
+// card is only rescheduled when this is its most recent review, so reviews
+// uploaded out of order leave the card scheduled by the latest one. It
+// returns false if a review with the same client id was already logged.
+func (m ReviewModel) Merge(review *Review) (bool, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var version int32
+	err = tx.QueryRow(`SELECT version FROM cards WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, review.CardID).Scan(&version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, ErrRecordNotFound
+		default:
+			return false, err
+		}
+	}
+
+	query := `
+		INSERT INTO reviews (client_id, card_id, reviewed_at, grade, next_review_date)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (client_id) DO NOTHING
+		RETURNING id
+	`
+	args := []interface{}{review.ClientID, review.CardID, review.ReviewedAt, review.Grade, review.NextReviewDate}
+	err = tx.QueryRow(query, args...).Scan(&review.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	var latest bool
+	query = `
+		SELECT NOT EXISTS (
+			SELECT 1 FROM reviews
+			WHERE card_id = $1 AND id <> $2 AND reviewed_at > $3
+		)
+	`
+	err = tx.QueryRow(query, review.CardID, review.ID, review.ReviewedAt).Scan(&latest)
+	if err != nil {
+		return false, err
+	}
+
+	event := map[string]interface{}{"card_id": review.CardID, "review": review}
+	if latest {
+		err = snapshotCard(tx, review.CardID, version)
+		if err != nil {
+			return false, err
+		}
+		query = `
+			WITH previous AS (
+				SELECT next_review_date FROM cards WHERE id = $1
+			)
+			UPDATE cards
+			SET next_review_date = $2, version = version + 1
+			WHERE id = $1
+			RETURNING (SELECT next_review_date FROM previous)
+		`
+		var previousReviewDate time.Time
+		err = tx.QueryRow(query, review.CardID, review.NextReviewDate).Scan(&previousReviewDate)
+		if err != nil {
+			return false, err
+		}
+		event["previous_review_date"] = previousReviewDate
+		event["next_review_date"] = review.NextReviewDate
+
+		err = recordEvents(tx, EventCardUpdated, []int64{review.CardID})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = recordEvent(tx, EventReviewCompleted, review.CardID, event)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// GetLatestForCards returns up to limit reviews of each of the cards, newest
+// first. If olderThan is set only reviews that come after it in that order
+// are returned, which pages through a card's reviews.
+func (m ReviewModel) GetLatestForCards(cardIDs []int64, olderThan *Review, limit int) (map[int64][]*Review, error) {
+	var afterTime time.Time
+	var afterID int64
+	if olderThan != nil {
+		afterTime, afterID = olderThan.ReviewedAt, olderThan.ID
+	}
+	query := `
+		SELECT id, client_id, card_id, reviewed_at, grade, next_review_date, txid
+		FROM (
+			SELECT *, row_number() OVER (PARTITION BY card_id ORDER BY reviewed_at DESC, id DESC) AS n
+			FROM reviews
+			WHERE card_id = ANY($1) AND ($3::bigint = 0 OR (reviewed_at, id) < ($2, $3))
+		) latest
+		WHERE n <= $4
+		ORDER BY card_id, reviewed_at DESC, id DESC
+	`
+	rows, err := m.DB.Query(query, pq.Array(cardIDs), afterTime, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := map[int64][]*Review{}
+	for rows.Next() {
+		review, err := scanReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		reviews[review.CardID] = append(reviews[review.CardID], review)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return reviews, nil
+}
+
+// GetSince returns up to limit settled reviews logged after the position, in
+// log order (see LogPosition).
+func (m ReviewModel) GetSince(after LogPosition, limit int) ([]*Review, error) {
+	query := fmt.Sprintf(`
+		SELECT id, client_id, card_id, reviewed_at, grade, next_review_date, txid
+		FROM reviews
+		WHERE (txid, id) > ($1, $2) AND txid < %s
+		ORDER BY txid, id
+		LIMIT $3
+	`, logHorizon)
+	rows, err := m.DB.Query(query, after.TxID, after.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []*Review{}
+	for rows.Next() {
+		review, err := scanReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, review)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return reviews, nil
+}
+
+// scanReview reads a row holding id, client_id, card_id, reviewed_at, grade,
+// next_review_date and txid.
+func scanReview(rows *sql.Rows) (*Review, error) {
+	var review Review
+	var grade sql.NullInt16
+	err := rows.Scan(&review.ID, &review.ClientID, &review.CardID, &review.ReviewedAt, &grade, &review.NextReviewDate, &review.TxID)
+	if err != nil {
+		return nil, err
+	}
+	if grade.Valid {
+		g := int(grade.Int16)
+		review.Grade = &g
+	}
+	return &review, nil
+}
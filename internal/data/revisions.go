@@ -0,0 +1,198 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// CardRevision is a snapshot of a card as it was at a given version, taken
+// just before CardModel.Update replaced it.
+type CardRevision struct {
+	CardID         int64          `json:"card_id"`
+	Version        int32          `json:"version"`
+	CreatedAt      time.Time      `json:"created_at"`
+	Title          string         `json:"title"`
+	Tags           []string       `json:"tags"`
+	Content        string         `json:"content"`
+	NextReviewDate time.Time      `json:"next_review_date"`
+	CodeSnippet    *CodeSnippet   `json:"code_snippet"`
+	Description    string         `json:"description"`
+	Audio          *CardAudio     `json:"audio"`
+	Occlusion      *CardOcclusion `json:"occlusion"`
+}
+
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+type RevisionModel struct {
+	DB *sql.DB
+}
+
+// RevisionFromCard returns the card's current state in revision form so it can
+// be diffed against stored revisions.
+func RevisionFromCard(card *Card) *CardRevision {
+	return &CardRevision{
+		CardID:         card.ID,
+		Version:        card.Version,
+		Title:          card.Title,
+		Tags:           card.Tags,
+		Content:        card.Content,
+		NextReviewDate: card.NextReviewDate,
+		CodeSnippet:    card.CodeSnippet,
+		Description:    card.Description,
+		Audio:          card.Audio,
+		Occlusion:      card.Occlusion,
+	}
+}
+
+// DiffRevisions lists the fields that differ between two revisions.
+func DiffRevisions(from, to *CardRevision) []FieldChange {
+	changes := []FieldChange{}
+	if from.Title != to.Title {
+		changes = append(changes, FieldChange{Field: "title", From: from.Title, To: to.Title})
+	}
+	if !reflect.DeepEqual(from.Tags, to.Tags) {
+		changes = append(changes, FieldChange{Field: "tags", From: from.Tags, To: to.Tags})
+	}
+	if from.Content != to.Content {
+		changes = append(changes, FieldChange{Field: "content", From: from.Content, To: to.Content})
+	}
+	if !from.NextReviewDate.Equal(to.NextReviewDate) {
+		changes = append(changes, FieldChange{Field: "next_review_date", From: from.NextReviewDate, To: to.NextReviewDate})
+	}
+	if !reflect.DeepEqual(from.CodeSnippet, to.CodeSnippet) {
+		changes = append(changes, FieldChange{Field: "code_snippet", From: from.CodeSnippet, To: to.CodeSnippet})
+	}
+	if from.Description != to.Description {
+		changes = append(changes, FieldChange{Field: "description", From: from.Description, To: to.Description})
+	}
+	if !reflect.DeepEqual(from.Audio, to.Audio) {
+		changes = append(changes, FieldChange{Field: "audio", From: from.Audio, To: to.Audio})
+	}
+	if !reflect.DeepEqual(from.Occlusion, to.Occlusion) {
+		changes = append(changes, FieldChange{Field: "occlusion", From: from.Occlusion, To: to.Occlusion})
+	}
+	return changes
+}
+
+// snapshotCard copies the card's current row into card_revisions. It returns
+// ErrEditConflict if the card is no longer at the expected version.
+func snapshotCard(tx *sql.Tx, id int64, version int32) error {
+	query := `
+		INSERT INTO card_revisions (card_id, version, title, tags, content, next_review_date, code_snippet, description, audio, occlusion)
+		SELECT id, version, title, tags, content, next_review_date, code_snippet, description, audio, occlusion
+		FROM cards
+		WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+	`
+	result, err := tx.Exec(query, id, version)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// snapshotCards copies the current rows of several cards into card_revisions.
+// The caller is expected to hold row locks on the cards.
+func snapshotCards(tx *sql.Tx, ids []int64) error {
+	query := `
+		INSERT INTO card_revisions (card_id, version, title, tags, content, next_review_date, code_snippet, description, audio, occlusion)
+		SELECT id, version, title, tags, content, next_review_date, code_snippet, description, audio, occlusion
+		FROM cards
+		WHERE id = ANY($1)
+	`
+	_, err := tx.Exec(query, pq.Array(ids))
+	return err
+}
+
+func (m RevisionModel) GetAllForCard(cardID int64) ([]*CardRevision, error) {
+	query := `
+		SELECT card_id, version, created_at, title, tags, content, next_review_date, code_snippet, description, audio, occlusion
+		FROM card_revisions
+		WHERE card_id = $1
+		ORDER BY version DESC
+	`
+	rows, err := m.DB.Query(query, cardID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*CardRevision{}
+	for rows.Next() {
+		var revision CardRevision
+		var s sql.NullString
+		err := rows.Scan(
+			&revision.CardID,
+			&revision.Version,
+			&revision.CreatedAt,
+			&revision.Title,
+			pq.Array(&revision.Tags),
+			&revision.Content,
+			&revision.NextReviewDate,
+			&revision.CodeSnippet,
+			&s,
+			&revision.Audio,
+			&revision.Occlusion,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if s.Valid {
+			revision.Description = s.String
+		}
+		revisions = append(revisions, &revision)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (m RevisionModel) Get(cardID int64, version int32) (*CardRevision, error) {
+	query := `
+		SELECT card_id, version, created_at, title, tags, content, next_review_date, code_snippet, description, audio, occlusion
+		FROM card_revisions
+		WHERE card_id = $1 AND version = $2
+	`
+	var revision CardRevision
+	var s sql.NullString
+	err := m.DB.QueryRow(query, cardID, version).Scan(
+		&revision.CardID,
+		&revision.Version,
+		&revision.CreatedAt,
+		&revision.Title,
+		pq.Array(&revision.Tags),
+		&revision.Content,
+		&revision.NextReviewDate,
+		&revision.CodeSnippet,
+		&s,
+		&revision.Audio,
+		&revision.Occlusion,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if s.Valid {
+		revision.Description = s.String
+	}
+	return &revision, nil
+}
@@ -0,0 +1,247 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MaxSyncChanges caps how many changed cards, and separately how many
+// reviews, a single sync response carries.
+const MaxSyncChanges = 1000
+
+var (
+	ErrInvalidSyncToken = errors.New("invalid sync token")
+	ErrSyncTokenExpired = errors.New("sync token expired")
+)
+
+// SyncToken is the decoded form of the opaque token handed to sync clients.
+// It holds the last card event and the position of the last review the
+// client has seen. While a client is still paging through its first sync,
+// CardID is the last card it has been sent and EventID is where the event log
+// stood when that sync began.
+type SyncToken struct {
+	EventID    int64 `json:"e"`
+	ReviewID   int64 `json:"r"`
+	ReviewTxID int64 `json:"rt,omitempty"`
+	CardID     int64 `json:"c,omitempty"`
+}
+
+func (t SyncToken) Encode() string {
+	js, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func DecodeSyncToken(s string) (*SyncToken, error) {
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidSyncToken
+	}
+	var t SyncToken
+	err = json.Unmarshal(js, &t)
+	if err != nil || t.EventID < 0 || t.ReviewID < 0 || t.ReviewTxID < 0 || t.CardID < 0 {
+		return nil, ErrInvalidSyncToken
+	}
+	return &t, nil
+}
+
+// Tombstone marks a card that has been deleted since the last sync.
+// DeletedAt is nil once the card has been purged from the trash.
+type Tombstone struct {
+	ID        int64      `json:"id"`
+	DeletedAt *time.Time `json:"deleted_at"`
+}
+
+type SyncChanges struct {
+	Cards      []*Card     `json:"cards"`
+	Tombstones []Tombstone `json:"tombstones"`
+	Reviews    []*Review   `json:"reviews"`
+	Token      SyncToken   `json:"-"`
+	HasMore    bool        `json:"has_more"`
+}
+
+type SyncModel struct {
+	DB *sql.DB
+}
+
+// Changes returns everything that changed after the token. Without a token
+// it starts a first sync, which pages through every card and the review log
+// from the start. Card changes are read from the event log, so a token older
+// than the log's retention period fails with ErrSyncTokenExpired and the
+// client must sync from scratch.
+func (m SyncModel) Changes(since *SyncToken) (*SyncChanges, error) {
+	changes := &SyncChanges{Cards: []*Card{}, Tombstones: []Tombstone{}}
+
+	var err error
+	switch {
+	case since == nil:
+		since = &SyncToken{}
+		// Take the position in the event log first, so that anything changed
+		// while the cards are read is sent again afterwards rather than missed.
+		var latest LogPosition
+		latest, err = EventModel{DB: m.DB}.Latest()
+		if err != nil {
+			return nil, err
+		}
+		changes.Token.EventID = latest.ID
+		err = m.allCards(0, changes)
+	case since.CardID > 0:
+		changes.Token.EventID = since.EventID
+		err = m.allCards(since.CardID, changes)
+	default:
+		err = m.changedCards(since.EventID, changes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	after := LogPosition{TxID: since.ReviewTxID, ID: since.ReviewID}
+	changes.Reviews, err = ReviewModel{DB: m.DB}.GetSince(after, MaxSyncChanges+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes.Reviews) > MaxSyncChanges {
+		changes.Reviews = changes.Reviews[:MaxSyncChanges]
+		changes.HasMore = true
+	}
+	if len(changes.Reviews) > 0 {
+		after = changes.Reviews[len(changes.Reviews)-1].Position()
+	}
+	changes.Token.ReviewID, changes.Token.ReviewTxID = after.ID, after.TxID
+	return changes, nil
+}
+
+// allCards reads a page of the cards after the one with the given id.
+func (m SyncModel) allCards(afterID int64, changes *SyncChanges) error {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM cards
+		WHERE deleted_at IS NULL AND id > $1
+		ORDER BY id
+		LIMIT $2
+	`, cardColumns(CardFieldSafeList))
+	rows, err := m.DB.Query(query, afterID, MaxSyncChanges+1)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var card Card
+		err := rows.Scan(card.scanTargets(CardFieldSafeList)...)
+		if err != nil {
+			return err
+		}
+		changes.Cards = append(changes.Cards, &card)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(changes.Cards) > MaxSyncChanges {
+		changes.Cards = changes.Cards[:MaxSyncChanges]
+		changes.HasMore = true
+		changes.Token.CardID = changes.Cards[len(changes.Cards)-1].ID
+	}
+	return nil
+}
+
+// changedCards reads a page of the cards changed after the event with the
+// given id, in the order the event log is read in (see LogPosition).
+func (m SyncModel) changedCards(eventID int64, changes *SyncChanges) error {
+	changes.Token.EventID = eventID
+
+	after, err := EventModel{DB: m.DB}.Position(eventID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			return ErrSyncTokenExpired
+		default:
+			return err
+		}
+	}
+
+	// Each changed card is reported once, at the position of its latest event.
+	query := fmt.Sprintf(`
+		SELECT card_id, id
+		FROM (
+			SELECT DISTINCT ON (card_id) card_id, txid, id
+			FROM card_events
+			WHERE (txid, id) > ($1, $2) AND txid < %s
+			ORDER BY card_id, txid DESC, id DESC
+		) latest
+		ORDER BY txid, id
+		LIMIT $3
+	`, logHorizon)
+	rows, err := m.DB.Query(query, after.TxID, after.ID, MaxSyncChanges+1)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	lastIDs := []int64{}
+	for rows.Next() {
+		var id, lastID int64
+		err := rows.Scan(&id, &lastID)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		lastIDs = append(lastIDs, lastID)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if len(ids) > MaxSyncChanges {
+		ids = ids[:MaxSyncChanges]
+		lastIDs = lastIDs[:MaxSyncChanges]
+		changes.HasMore = true
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	changes.Token.EventID = lastIDs[len(lastIDs)-1]
+
+	query = fmt.Sprintf(`
+		SELECT %s, deleted_at
+		FROM cards
+		WHERE id = ANY($1)
+		ORDER BY id
+	`, cardColumns(CardFieldSafeList))
+	cardRows, err := m.DB.Query(query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer cardRows.Close()
+
+	found := map[int64]bool{}
+	for cardRows.Next() {
+		var card Card
+		err := cardRows.Scan(append(card.scanTargets(CardFieldSafeList), &card.DeletedAt)...)
+		if err != nil {
+			return err
+		}
+		found[card.ID] = true
+		if card.DeletedAt != nil {
+			changes.Tombstones = append(changes.Tombstones, Tombstone{ID: card.ID, DeletedAt: card.DeletedAt})
+			continue
+		}
+		changes.Cards = append(changes.Cards, &card)
+	}
+	if err = cardRows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if !found[id] {
+			changes.Tombstones = append(changes.Tombstones, Tombstone{ID: id})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TagSeparator splits a hierarchical tag such as
+// "algorithms::graphs::shortest-path" into its segments.
+const TagSeparator = "::"
+
+type TagNode struct {
+	Name     string     `json:"name"`
+	Path     string     `json:"path"`
+	Count    int        `json:"count"`
+	Children []*TagNode `json:"children,omitempty"`
+}
+
+type TagModel struct {
+	DB *sql.DB
+}
+
+// ValidTag reports whether every segment of the tag is non-empty and free of
+// surrounding whitespace. Commas are rejected because tag filters are read
+// from a comma-separated query string.
+func ValidTag(tag string) bool {
+	if strings.Contains(tag, ",") {
+		return false
+	}
+	for _, segment := range strings.Split(tag, TagSeparator) {
+		if segment == "" || strings.TrimSpace(segment) != segment {
+			return false
+		}
+	}
+	return true
+}
+
+// tagFilterClause matches cards that carry every tag in the array parameter,
+// either exactly or as an ancestor of one of the card's tags, so filtering by
+// "algorithms::graphs" also returns cards tagged "algorithms::graphs::bfs".
+// tag_paths is generated from tags and holds each tag and all its ancestors,
+// so the match is a containment test that the GIN index on it can answer.
+func tagFilterClause(param string) string {
+	return fmt.Sprintf(`tag_paths @> %s::text[]`, param)
+}
+
+// tagTree builds the tag hierarchy from the number of cards under each path.
+type tagTree struct {
+	nodes map[string]*TagNode
+}
+
+func newTagTree() *tagTree {
+	return &tagTree{nodes: map[string]*TagNode{}}
+}
+
+// add records the number of cards tagged with the path or any of its
+// descendants.
+func (t *tagTree) add(path string, count int) {
+	segments := strings.Split(path, TagSeparator)
+	t.nodes[path] = &TagNode{Name: segments[len(segments)-1], Path: path, Count: count}
+}
+
+// sorted links each node to its parent and returns the root nodes, with the
+// nodes at every level sorted by path. A node's parent is found from the same
+// split of its path that tag_paths uses, so even tags that wouldn't pass
+// ValidTag, such as "a:::b", end up under a parent that exists.
+func (t *tagTree) sorted() []*TagNode {
+	roots := []*TagNode{}
+	for path, node := range t.nodes {
+		segments := strings.Split(path, TagSeparator)
+		parent, ok := t.nodes[strings.Join(segments[:len(segments)-1], TagSeparator)]
+		if len(segments) == 1 || !ok {
+			roots = append(roots, node)
+			continue
+		}
+		parent.Children = append(parent.Children, node)
+	}
+
+	var sortNodes func(nodes []*TagNode)
+	sortNodes = func(nodes []*TagNode) {
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].Path < nodes[j].Path })
+		for _, node := range nodes {
+			sortNodes(node.Children)
+		}
+	}
+	sortNodes(roots)
+	return roots
+}
+
+// GetTree returns the tag hierarchy. Each node counts the cards tagged with
+// that path or any of its descendants. tag_paths already holds every prefix
+// of a card's tags once, split on the separator, so the counting is done by
+// the database and only one row per path is read.
+func (t TagModel) GetTree() ([]*TagNode, error) {
+	query := `
+		SELECT path, count(*)
+		FROM cards, unnest(tag_paths) AS path
+		WHERE deleted_at IS NULL
+		GROUP BY path`
+
+	rows, err := t.DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tree := newTagTree()
+	for rows.Next() {
+		var path string
+		var count int
+		err := rows.Scan(&path, &count)
+		if err != nil {
+			return nil, err
+		}
+		tree.add(path, count)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tree.sorted(), nil
+}
@@ -0,0 +1,123 @@
+package data
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestValidTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want bool
+	}{
+		{"go", true},
+		{"algorithms::graphs::bfs", true},
+		{"c++", true},
+		{"", false},
+		{"a::", false},
+		{"::a", false},
+		{"a::::b", false},
+		{" a", false},
+		{"a:: b", false},
+		{"a,b", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			if got := ValidTag(tt.tag); got != tt.want {
+				t.Errorf("ValidTag(%q) = %v; want %v", tt.tag, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTagTree(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths map[string]int
+		want  string
+	}{
+		{
+			name:  "empty",
+			paths: nil,
+			want:  `[]`,
+		},
+		{
+			name: "nested",
+			paths: map[string]int{
+				"algorithms":              2,
+				"algorithms::graphs":      1,
+				"algorithms::graphs::bfs": 1,
+				"algorithms::sorting":     1,
+				"go":                      1,
+			},
+			want: `[
+				{"name": "algorithms", "path": "algorithms", "count": 2, "children": [
+					{"name": "graphs", "path": "algorithms::graphs", "count": 1, "children": [
+						{"name": "bfs", "path": "algorithms::graphs::bfs", "count": 1}
+					]},
+					{"name": "sorting", "path": "algorithms::sorting", "count": 1}
+				]},
+				{"name": "go", "path": "go", "count": 1}
+			]`,
+		},
+		{
+			name: "sorted by path",
+			paths: map[string]int{
+				"z::b": 1,
+				"z":    2,
+				"m":    1,
+				"z::a": 1,
+			},
+			want: `[
+				{"name": "m", "path": "m", "count": 1},
+				{"name": "z", "path": "z", "count": 2, "children": [
+					{"name": "a", "path": "z::a", "count": 1},
+					{"name": "b", "path": "z::b", "count": 1}
+				]}
+			]`,
+		},
+		{
+			// The paths tag_paths gives for "a:::b", "a::::b" and "a::".
+			name: "legacy tags with stray colons",
+			paths: map[string]int{
+				"a":      3,
+				"a:::b":  1,
+				"a::":    2,
+				"a::::b": 1,
+			},
+			want: `[
+				{"name": "a", "path": "a", "count": 3, "children": [
+					{"name": "", "path": "a::", "count": 2, "children": [
+						{"name": "b", "path": "a::::b", "count": 1}
+					]},
+					{"name": ":b", "path": "a:::b", "count": 1}
+				]}
+			]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := newTagTree()
+			for path, count := range tt.paths {
+				tree.add(path, count)
+			}
+			js, err := json.Marshal(tree.sorted())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got, want interface{}
+			json.Unmarshal(js, &got)
+			err = json.Unmarshal([]byte(tt.want), &want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %s", js)
+			}
+		})
+	}
+}
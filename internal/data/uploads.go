@@ -0,0 +1,150 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/vynquoc/cs-flash-cards/internal/validator"
+)
+
+const (
+	UploadPending   = "pending"
+	UploadCompleted = "completed"
+)
+
+var sha256RX = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Upload is a direct-to-storage upload that a client has been given a
+// presigned URL for. It becomes an attachment once the client completes it.
+type Upload struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Key          string    `json:"key"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256"`
+	UploadedBy   string    `json:"-"`
+	Status       string    `json:"status"`
+	AttachmentID *int64    `json:"attachment_id"`
+}
+
+type UploadModel struct {
+	DB *sql.DB
+}
+
+func ValidateUpload(v *validator.Validator, upload *Upload, maxBytes int64, contentTypes ...string) {
+	v.Check(upload.ContentType != "", "content_type", "must be provided")
+	v.Check(upload.ContentType == "" || validator.In(upload.ContentType, contentTypes...), "content_type", "must be one of "+strings.Join(contentTypes, ", "))
+	v.Check(upload.Size > 0, "size", "must be greater than zero")
+	v.Check(upload.Size <= maxBytes, "size", fmt.Sprintf("must not be more than %d bytes", maxBytes))
+	v.Check(validator.Matches(upload.SHA256, sha256RX), "sha256", "must be a hex-encoded SHA-256 hash")
+}
+
+func (m UploadModel) Insert(upload *Upload) error {
+	query := `
+		INSERT INTO uploads (expires_at, key, content_type, size, sha256, uploaded_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, status
+	`
+	args := []interface{}{upload.ExpiresAt, upload.Key, upload.ContentType, upload.Size, upload.SHA256, upload.UploadedBy}
+	return m.DB.QueryRow(query, args...).Scan(&upload.ID, &upload.CreatedAt, &upload.Status)
+}
+
+func (m UploadModel) Get(id int64) (*Upload, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, created_at, expires_at, key, content_type, size, sha256, uploaded_by, status, attachment_id
+		FROM uploads
+		WHERE id = $1
+	`
+	var upload Upload
+	err := m.DB.QueryRow(query, id).Scan(
+		&upload.ID,
+		&upload.CreatedAt,
+		&upload.ExpiresAt,
+		&upload.Key,
+		&upload.ContentType,
+		&upload.Size,
+		&upload.SHA256,
+		&upload.UploadedBy,
+		&upload.Status,
+		&upload.AttachmentID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &upload, nil
+}
+
+// Complete marks a pending upload as completed by the attachment. It fails
+// with ErrEditConflict if the upload was completed concurrently.
+func (m UploadModel) Complete(upload *Upload, attachmentID int64) error {
+	query := `
+		UPDATE uploads
+		SET status = $1, attachment_id = $2
+		WHERE id = $3 AND status = $4
+	`
+	result, err := m.DB.Exec(query, UploadCompleted, attachmentID, upload.ID, UploadPending)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	upload.Status = UploadCompleted
+	upload.AttachmentID = &attachmentID
+	return nil
+}
+
+// DeleteExpired deletes uploads that expired before the cutoff. It returns
+// the storage keys of those that were never completed and that no attachment
+// uses, as the client may have uploaded the file without completing it.
+func (m UploadModel) DeleteExpired(cutoff time.Time) ([]string, error) {
+	query := `
+		WITH expired AS (
+			DELETE FROM uploads
+			WHERE expires_at < $1
+			RETURNING key, status
+		)
+		SELECT DISTINCT key
+		FROM expired e
+		WHERE status = $2
+		AND NOT EXISTS (SELECT 1 FROM attachments WHERE keys @> ARRAY[e.key])
+		AND NOT EXISTS (SELECT 1 FROM uploads u WHERE u.key = e.key AND u.expires_at >= $1)
+	`
+	rows, err := m.DB.Query(query, cutoff, UploadPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		err := rows.Scan(&key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -0,0 +1,348 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/vynquoc/cs-flash-cards/internal/validator"
+)
+
+const (
+	EventCardCreated     = "card.created"
+	EventCardUpdated     = "card.updated"
+	EventCardDeleted     = "card.deleted"
+	EventReviewCompleted = "review.completed"
+	EventCardsDue        = "cards.due"
+)
+
+var WebhookEvents = []string{
+	EventCardCreated,
+	EventCardUpdated,
+	EventCardDeleted,
+	EventReviewCompleted,
+	EventCardsDue,
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+type Webhook struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+}
+
+type WebhookDelivery struct {
+	ID            int64             `json:"id"`
+	WebhookID     int64             `json:"webhook_id"`
+	CreatedAt     time.Time         `json:"created_at"`
+	Event         string            `json:"event"`
+	Payload       json.RawMessage   `json:"payload"`
+	Status        string            `json:"status"`
+	Attempts      int               `json:"attempts"`
+	NextAttemptAt time.Time         `json:"next_attempt_at"`
+	AttemptLog    []*WebhookAttempt `json:"attempt_log"`
+	Webhook       *Webhook          `json:"-"`
+}
+
+type WebhookAttempt struct {
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  *int      `json:"status_code"`
+	Error       string    `json:"error,omitempty"`
+	DurationMS  int       `json:"duration_ms"`
+}
+
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	u, err := url.Parse(webhook.URL)
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+	v.Check(len(webhook.Events) >= 1, "events", "must contain at least 1 event")
+	v.Check(validator.Unique(webhook.Events), "events", "must not contain duplicate values")
+	for _, event := range webhook.Events {
+		v.Check(validator.In(event, WebhookEvents...), "events", fmt.Sprintf("%q is not a valid event", event))
+	}
+}
+
+func (m WebhookModel) Insert(webhook *Webhook) error {
+	query := `
+		INSERT INTO webhooks (url, secret, events, active)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+	args := []interface{}{webhook.URL, webhook.Secret, pq.Array(webhook.Events), webhook.Active}
+	return m.DB.QueryRow(query, args...).Scan(&webhook.ID, &webhook.CreatedAt)
+}
+
+func (m WebhookModel) Get(id int64) (*Webhook, error) {
+	query := `
+		SELECT id, created_at, url, secret, events, active
+		FROM webhooks
+		WHERE id = $1
+	`
+	var webhook Webhook
+	err := m.DB.QueryRow(query, id).Scan(
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.URL,
+		&webhook.Secret,
+		pq.Array(&webhook.Events),
+		&webhook.Active,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &webhook, nil
+}
+
+func (m WebhookModel) GetAll() ([]*Webhook, error) {
+	query := `
+		SELECT id, created_at, url, secret, events, active
+		FROM webhooks
+		ORDER BY id
+	`
+	rows, err := m.DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		var webhook Webhook
+		err := rows.Scan(
+			&webhook.ID,
+			&webhook.CreatedAt,
+			&webhook.URL,
+			&webhook.Secret,
+			pq.Array(&webhook.Events),
+			&webhook.Active,
+		)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, &webhook)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (m WebhookModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	result, err := m.DB.Exec(`DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// EnqueueOncePerDay writes a pending delivery of a digest event such as
+// cards.due to every active webhook subscribed to it, skipping those that
+// have already been sent the event today. Card events are queued by
+// recordEvent instead, in the transaction that changes the card.
+func (m WebhookModel) EnqueueOncePerDay(event string, payload interface{}) error {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT w.id, $1, $2::jsonb
+		FROM webhooks w
+		WHERE w.active AND $1 = ANY(w.events)
+		AND NOT EXISTS (
+			SELECT 1 FROM webhook_deliveries d
+			WHERE d.webhook_id = w.id AND d.event = $1 AND d.created_at >= CURRENT_DATE
+		)
+	`
+	_, err = m.DB.Exec(query, event, js)
+	return err
+}
+
+// ClaimDue leases up to limit pending deliveries that are due, pushing their
+// next attempt back by lease so that another worker won't pick them up while
+// they are in flight.
+func (m WebhookModel) ClaimDue(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = $2
+		FROM webhooks w
+		WHERE w.id = d.webhook_id
+		AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.id, d.webhook_id, d.created_at, d.event, d.payload, d.status, d.attempts, d.next_attempt_at, w.url, w.secret
+	`
+	rows, err := m.DB.Query(query, limit, time.Now().Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		delivery := WebhookDelivery{Webhook: &Webhook{}}
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.CreatedAt,
+			&delivery.Event,
+			&delivery.Payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.NextAttemptAt,
+			&delivery.Webhook.URL,
+			&delivery.Webhook.Secret,
+		)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Webhook.ID = delivery.WebhookID
+		deliveries = append(deliveries, &delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// RecordAttempt logs a delivery attempt and moves the delivery to its new
+// status. nextAttemptAt is only used while the delivery is still pending.
+func (m WebhookModel) RecordAttempt(delivery *WebhookDelivery, attempt *WebhookAttempt, status string, nextAttemptAt time.Time) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO webhook_delivery_attempts (delivery_id, attempted_at, status_code, error, duration_ms)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+	`
+	_, err = tx.Exec(query, delivery.ID, attempt.AttemptedAt, attempt.StatusCode, attempt.Error, attempt.DurationMS)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = attempts + 1, next_attempt_at = $2
+		WHERE id = $3
+		RETURNING attempts
+	`
+	err = tx.QueryRow(query, status, nextAttemptAt, delivery.ID).Scan(&delivery.Attempts)
+	if err != nil {
+		return err
+	}
+	delivery.Status = status
+	return tx.Commit()
+}
+
+// GetDeliveries returns a page of the webhook's deliveries, newest first,
+// each with its full attempt log.
+func (m WebhookModel) GetDeliveries(webhookID int64, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), id, webhook_id, created_at, event, payload, status, attempts, next_attempt_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := m.DB.Query(query, webhookID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	byID := map[int64]*WebhookDelivery{}
+	ids := []int64{}
+	totalRecords := 0
+	for rows.Next() {
+		delivery := WebhookDelivery{AttemptLog: []*WebhookAttempt{}}
+		err := rows.Scan(
+			&totalRecords,
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.CreatedAt,
+			&delivery.Event,
+			&delivery.Payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.NextAttemptAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		deliveries = append(deliveries, &delivery)
+		byID[delivery.ID] = &delivery
+		ids = append(ids, delivery.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	if len(ids) > 0 {
+		query = `
+			SELECT delivery_id, attempted_at, status_code, coalesce(error, ''), duration_ms
+			FROM webhook_delivery_attempts
+			WHERE delivery_id = ANY($1)
+			ORDER BY id
+		`
+		attemptRows, err := m.DB.Query(query, pq.Array(ids))
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		defer attemptRows.Close()
+
+		for attemptRows.Next() {
+			var deliveryID int64
+			var attempt WebhookAttempt
+			err := attemptRows.Scan(&deliveryID, &attempt.AttemptedAt, &attempt.StatusCode, &attempt.Error, &attempt.DurationMS)
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+			byID[deliveryID].AttemptLog = append(byID[deliveryID].AttemptLog, &attempt)
+		}
+		if err = attemptRows.Err(); err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return deliveries, metadata, nil
+}
@@ -0,0 +1,69 @@
+package graph
+
+import (
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+	"github.com/vynquoc/cs-flash-cards/internal/validator"
+)
+
+// maxPageSize caps the first argument of connections, as page_size is
+// capped in the REST API.
+const maxPageSize = 100
+
+// cardSorts maps the CardSort enum to the sort values the card model takes.
+var cardSorts = map[CardSort]string{
+	CardSortID:                 "id",
+	CardSortTitle:              "title",
+	CardSortCreatedAt:          "created_at",
+	CardSortNextReviewDate:     "next_review_date",
+	CardSortIDDesc:             "-id",
+	CardSortTitleDesc:          "-title",
+	CardSortCreatedAtDesc:      "-created_at",
+	CardSortNextReviewDateDesc: "-next_review_date",
+}
+
+// validatePageSize checks a connection's first argument and returns the page
+// size it asks for. The schema gives it a default, so it is only nil when a
+// client passes null.
+func validatePageSize(v *validator.Validator, first *int) int {
+	if first == nil {
+		v.AddError("first", "must be provided")
+		return 0
+	}
+	v.Check(*first > 0, "first", "must be greater than 0")
+	v.Check(*first <= maxPageSize, "first", "must be a maximum of 100")
+	return *first
+}
+
+// filterArgs names the arguments behind the filters data.ValidateFilters
+// checks, so its errors point at what the client sent.
+var filterArgs = map[string]string{
+	"cursor":    "after",
+	"page_size": "first",
+}
+
+// validateFilters runs data.ValidateFilters, reporting each error against
+// the argument it came from. An argument that already has an error keeps it.
+func validateFilters(v *validator.Validator, filters data.Filters) {
+	fv := validator.New()
+	data.ValidateFilters(fv, filters)
+	for key, message := range fv.Errors {
+		if arg, ok := filterArgs[key]; ok {
+			key = arg
+		}
+		v.AddError(key, message)
+	}
+}
+
+// validationError reports invalid arguments the way the REST API reports a
+// failed validation: a map from argument to message, here in the error's
+// extensions.
+func validationError(v *validator.Validator) *gqlerror.Error {
+	return &gqlerror.Error{
+		Message: "invalid arguments",
+		Extensions: map[string]interface{}{
+			"code":   "BAD_USER_INPUT",
+			"errors": v.Errors,
+		},
+	}
+}
@@ -0,0 +1,41 @@
+package graph
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// reviewCursor is the decoded form of the cursor of an edge in a card's
+// reviews: the position of the review, newest first. ReviewedAt is in
+// microseconds, which is what PostgreSQL keeps.
+type reviewCursor struct {
+	ReviewedAt int64 `json:"t"`
+	ID         int64 `json:"i"`
+}
+
+func reviewCursorFor(review *data.Review) reviewCursor {
+	return reviewCursor{ReviewedAt: review.ReviewedAt.UnixMicro(), ID: review.ID}
+}
+
+func (c reviewCursor) encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeReviewCursor(s string) (reviewCursor, error) {
+	var c reviewCursor
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, errInvalidCursor
+	}
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.ID < 1 {
+		return reviewCursor{}, errInvalidCursor
+	}
+	return c, nil
+}
@@ -0,0 +1,133 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+)
+
+// Limits bounds the cost of a single operation.
+type Limits struct {
+	// MaxDepth is how deeply selections may nest, such as a card's reviews'
+	// card's reviews.
+	MaxDepth int
+	// MaxComplexity caps the estimated number of fields resolved, with each
+	// connection counting its fields once per item asked for.
+	MaxComplexity int
+}
+
+// NewHandler returns an http.Handler serving GraphQL operations over POST.
+// Errors other than those meant for the client, such as a failed query, are
+// logged and reported without detail, as the REST API does.
+func NewHandler(models data.Models, logger *slog.Logger, limits Limits) *handler.Server {
+	cfg := Config{Resolvers: &Resolver{Models: models}}
+	cfg.Complexity.Query.Cards = func(childComplexity int, first *int, after *string, title *string, tags []string, sort *CardSort) int {
+		return connectionComplexity(childComplexity, first)
+	}
+	cfg.Complexity.Card.Reviews = func(childComplexity int, first *int, after *string) int {
+		return connectionComplexity(childComplexity, first)
+	}
+
+	srv := handler.New(NewExecutableSchema(cfg))
+	srv.AddTransport(transport.POST{})
+	srv.Use(extension.Introspection{})
+	srv.Use(depthLimit(limits.MaxDepth))
+	srv.Use(extension.FixedComplexityLimit(limits.MaxComplexity))
+
+	srv.AroundOperations(func(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+		return next(withLoaders(ctx, newLoaders(models)))
+	})
+
+	srv.SetErrorPresenter(func(ctx context.Context, err error) *gqlerror.Error {
+		gqlErr := graphql.DefaultErrorPresenter(ctx, err)
+		// Errors meant for the client are created as gqlerrors; anything
+		// else is wrapped by gqlgen and keeps the original in Err.
+		if gqlErr.Err == nil {
+			return gqlErr
+		}
+		logger.Error(err.Error(), "path", gqlErr.Path.String())
+		return &gqlerror.Error{
+			Message:    "the server encountered a problem and could not process your request",
+			Path:       gqlErr.Path,
+			Extensions: map[string]interface{}{"code": "INTERNAL_SERVER_ERROR"},
+		}
+	})
+	srv.SetRecoverFunc(func(ctx context.Context, err interface{}) error {
+		return fmt.Errorf("%v", err)
+	})
+
+	return srv
+}
+
+// connectionComplexity counts a connection's fields once for every item it
+// may return.
+func connectionComplexity(childComplexity int, first *int) int {
+	n := 1
+	if first != nil && *first > 0 {
+		n = *first
+	}
+	return 1 + n*childComplexity
+}
+
+// depthLimit rejects operations whose selections nest more than max levels
+// deep. Introspection fields are left out, as the standard introspection
+// query is deeper than any sensible limit.
+type depthLimit int
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationContextMutator
+} = depthLimit(0)
+
+func (d depthLimit) ExtensionName() string {
+	return "DepthLimit"
+}
+
+func (d depthLimit) Validate(schema graphql.ExecutableSchema) error {
+	if d < 1 {
+		return fmt.Errorf("depth limit must be greater than 0")
+	}
+	return nil
+}
+
+func (d depthLimit) MutateOperationContext(ctx context.Context, rc *graphql.OperationContext) *gqlerror.Error {
+	if depth := selectionDepth(rc.Operation.SelectionSet); depth > int(d) {
+		err := gqlerror.Errorf("operation has depth %d, which exceeds the limit of %d", depth, d)
+		err.Extensions = map[string]interface{}{"code": "DEPTH_LIMIT_EXCEEDED"}
+		return err
+	}
+	return nil
+}
+
+// selectionDepth returns how many levels of fields a selection set nests.
+// Operations are validated before this runs, so fragments can't form cycles.
+func selectionDepth(set ast.SelectionSet) int {
+	depth := 0
+	for _, sel := range set {
+		var d int
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(sel.Name, "__") {
+				continue
+			}
+			d = 1 + selectionDepth(sel.SelectionSet)
+		case *ast.InlineFragment:
+			d = selectionDepth(sel.SelectionSet)
+		case *ast.FragmentSpread:
+			if sel.Definition != nil {
+				d = selectionDepth(sel.Definition.SelectionSet)
+			}
+		}
+		depth = max(depth, d)
+	}
+	return depth
+}
@@ -0,0 +1,130 @@
+package graph
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+)
+
+// These operations are all rejected or answered before any model is used,
+// so the handler runs without a database.
+func TestHandler(t *testing.T) {
+	h := NewHandler(data.Models{}, slog.New(slog.NewTextHandler(io.Discard, nil)), Limits{MaxDepth: 4, MaxComplexity: 500})
+
+	tests := []struct {
+		name        string
+		query       string
+		wantCode    string
+		wantError   string
+		wantMessage string
+	}{
+		{
+			name:  "within limits",
+			query: `{ __typename }`,
+		},
+		{
+			name:  "introspection ignores depth",
+			query: `{ __schema { types { fields { type { ofType { name } } } } } }`,
+		},
+		{
+			name:     "too deep",
+			query:    `{ card(id: 1) { reviews { edges { node { card { id } } } } } }`,
+			wantCode: "DEPTH_LIMIT_EXCEEDED",
+		},
+		{
+			name: "too deep through fragments",
+			query: `{ card(id: 1) { ...reviews } }
+				fragment reviews on Card { reviews { edges { ... on ReviewEdge { node { card { id } } } } } }`,
+			wantCode: "DEPTH_LIMIT_EXCEEDED",
+		},
+		{
+			name:     "too complex",
+			query:    `{ cards(first: 100) { edges { node { id title content tags } } } }`,
+			wantCode: "COMPLEXITY_LIMIT_EXCEEDED",
+		},
+		{
+			name:      "invalid page size",
+			query:     `{ cards(first: 0) { totalCount } }`,
+			wantCode:  "BAD_USER_INPUT",
+			wantError: "first",
+		},
+		{
+			name:      "invalid cursor",
+			query:     `{ cards(after: "nope") { totalCount } }`,
+			wantCode:  "BAD_USER_INPUT",
+			wantError: "after",
+		},
+		{
+			name:        "invalid id",
+			query:       `{ card(id: "x") { id } }`,
+			wantMessage: "not a valid id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]string{"query": tt.query})
+			r := httptest.NewRequest(http.MethodPost, "/v1/graphql", strings.NewReader(string(body)))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			var resp struct {
+				Errors []struct {
+					Message    string
+					Extensions struct {
+						Code   string
+						Errors map[string]string
+					}
+				}
+			}
+			err := json.Unmarshal(w.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatalf("decoding %s: %v", w.Body, err)
+			}
+
+			if tt.wantMessage != "" {
+				if len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, tt.wantMessage) {
+					t.Errorf("errors = %+v; want %q", resp.Errors, tt.wantMessage)
+				}
+				return
+			}
+			if tt.wantCode == "" {
+				if len(resp.Errors) > 0 {
+					t.Errorf("errors = %+v; want none", resp.Errors)
+				}
+				return
+			}
+			if len(resp.Errors) != 1 || resp.Errors[0].Extensions.Code != tt.wantCode {
+				t.Fatalf("errors = %+v; want code %s", resp.Errors, tt.wantCode)
+			}
+			if tt.wantError != "" && resp.Errors[0].Extensions.Errors[tt.wantError] == "" {
+				t.Errorf("errors = %v; want an error for %s", resp.Errors[0].Extensions.Errors, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestReviewCursor(t *testing.T) {
+	review := &data.Review{ID: 42, ReviewedAt: time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC)}
+	got, err := decodeReviewCursor(reviewCursorFor(review).encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := reviewCursorFor(review); got != want {
+		t.Errorf("decoded cursor = %+v; want %+v", got, want)
+	}
+
+	for _, s := range []string{"", "nope", "e30", "eyJpIjowfQ"} {
+		if _, err := decodeReviewCursor(s); err == nil {
+			t.Errorf("decodeReviewCursor(%q) succeeded; want error", s)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package graph
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// loaderWait is how long a loader collects keys before fetching them.
+	// Sibling fields resolve concurrently, so this is enough for every item
+	// in a list to ask for its key.
+	loaderWait = 2 * time.Millisecond
+	// loaderMaxBatch caps how many keys are fetched with one query.
+	loaderMaxBatch = 500
+)
+
+// loader batches the keys asked for by concurrently resolving fields into
+// one fetch, and caches the results for the rest of the operation, so that a
+// list of cards doesn't make one query per card. Keys missing from the
+// fetched map load as the zero value.
+type loader[K comparable, V any] struct {
+	fetch func(keys []K) (map[K]V, error)
+
+	mu    sync.Mutex
+	batch *batch[K, V]
+	cache map[K]*batch[K, V]
+}
+
+type batch[K comparable, V any] struct {
+	keys       []K
+	dispatched bool
+	done       chan struct{}
+	results    map[K]V
+	err        error
+}
+
+func newLoader[K comparable, V any](fetch func(keys []K) (map[K]V, error)) *loader[K, V] {
+	return &loader[K, V]{fetch: fetch, cache: map[K]*batch[K, V]{}}
+}
+
+func (l *loader[K, V]) Load(key K) (V, error) {
+	l.mu.Lock()
+	b, ok := l.cache[key]
+	if !ok {
+		b = l.batch
+		if b == nil {
+			b = &batch[K, V]{done: make(chan struct{})}
+			l.batch = b
+			time.AfterFunc(loaderWait, func() { l.dispatch(b) })
+		}
+		b.keys = append(b.keys, key)
+		l.cache[key] = b
+		if len(b.keys) >= loaderMaxBatch {
+			go l.dispatch(b)
+		}
+	}
+	l.mu.Unlock()
+
+	<-b.done
+	return b.results[key], b.err
+}
+
+// Prime caches a value fetched some other way, such as a card read as part
+// of a list, unless the key is already loaded or being loaded.
+func (l *loader[K, V]) Prime(key K, value V) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.cache[key]; ok {
+		return
+	}
+	b := &batch[K, V]{dispatched: true, done: make(chan struct{}), results: map[K]V{key: value}}
+	close(b.done)
+	l.cache[key] = b
+}
+
+func (l *loader[K, V]) dispatch(b *batch[K, V]) {
+	l.mu.Lock()
+	if b.dispatched {
+		l.mu.Unlock()
+		return
+	}
+	b.dispatched = true
+	if l.batch == b {
+		l.batch = nil
+	}
+	l.mu.Unlock()
+
+	defer close(b.done)
+	defer func() {
+		// This runs outside of any resolver, so a panic would otherwise take
+		// the whole server down.
+		if err := recover(); err != nil {
+			b.err = fmt.Errorf("%v", err)
+		}
+	}()
+	b.results, b.err = l.fetch(b.keys)
+}
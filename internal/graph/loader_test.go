@@ -0,0 +1,83 @@
+package graph
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLoaderBatches(t *testing.T) {
+	var calls atomic.Int32
+	l := newLoader(func(keys []int) (map[int]int, error) {
+		calls.Add(1)
+		results := map[int]int{}
+		for _, key := range keys {
+			if key != 3 {
+				results[key] = key * 10
+			}
+		}
+		return results, nil
+	})
+
+	var wg sync.WaitGroup
+	got := make([]int, 5)
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, err := l.Load(i % 4)
+			if err != nil {
+				t.Errorf("Load(%d) error: %v", i%4, err)
+			}
+			got[i] = v
+		}(i)
+	}
+	wg.Wait()
+
+	want := []int{0, 10, 20, 0, 0}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Load(%d) = %d; want %d", i%4, got[i], want[i])
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("fetched %d times; want 1", n)
+	}
+
+	// Loaded keys are cached.
+	if v, _ := l.Load(2); v != 20 {
+		t.Errorf("cached Load(2) = %d; want 20", v)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("fetched %d times after a cached load; want 1", n)
+	}
+}
+
+func TestLoaderPrime(t *testing.T) {
+	l := newLoader(func(keys []int) (map[int]int, error) {
+		t.Errorf("fetched %v; want primed value", keys)
+		return nil, nil
+	})
+	l.Prime(1, 100)
+	if v, err := l.Load(1); v != 100 || err != nil {
+		t.Errorf("Load(1) = %d, %v; want 100, nil", v, err)
+	}
+}
+
+func TestLoaderErrors(t *testing.T) {
+	errFetch := errors.New("fetch failed")
+	l := newLoader(func(keys []int) (map[int]int, error) {
+		return nil, errFetch
+	})
+	if _, err := l.Load(1); !errors.Is(err, errFetch) {
+		t.Errorf("Load error = %v; want %v", err, errFetch)
+	}
+
+	l = newLoader(func(keys []int) (map[int]int, error) {
+		panic("boom")
+	})
+	if _, err := l.Load(1); err == nil || err.Error() != "boom" {
+		t.Errorf("Load error = %v; want boom", err)
+	}
+}
@@ -0,0 +1,75 @@
+package graph
+
+import (
+	"context"
+	"time"
+
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+)
+
+// loaders holds the batching loaders for one operation.
+type loaders struct {
+	cards   *loader[int64, *data.Card]
+	reviews *loader[reviewsKey, []*data.Review]
+}
+
+// reviewsKey asks for a page of a card's reviews. Keys for the same page of
+// different cards are fetched together.
+type reviewsKey struct {
+	cardID int64
+	after  reviewCursor
+	limit  int
+}
+
+type loadersKey struct{}
+
+func newLoaders(models data.Models) *loaders {
+	return &loaders{
+		cards: newLoader(func(ids []int64) (map[int64]*data.Card, error) {
+			cards, err := models.Cards.GetMany(ids)
+			if err != nil {
+				return nil, err
+			}
+			byID := make(map[int64]*data.Card, len(cards))
+			for _, card := range cards {
+				byID[card.ID] = card
+			}
+			return byID, nil
+		}),
+		reviews: newLoader(func(keys []reviewsKey) (map[reviewsKey][]*data.Review, error) {
+			type page struct {
+				after reviewCursor
+				limit int
+			}
+			pages := map[page][]int64{}
+			for _, key := range keys {
+				p := page{key.after, key.limit}
+				pages[p] = append(pages[p], key.cardID)
+			}
+
+			results := make(map[reviewsKey][]*data.Review, len(keys))
+			for p, cardIDs := range pages {
+				var olderThan *data.Review
+				if p.after.ID != 0 {
+					olderThan = &data.Review{ID: p.after.ID, ReviewedAt: time.UnixMicro(p.after.ReviewedAt)}
+				}
+				reviews, err := models.Reviews.GetLatestForCards(cardIDs, olderThan, p.limit)
+				if err != nil {
+					return nil, err
+				}
+				for _, cardID := range cardIDs {
+					results[reviewsKey{cardID, p.after, p.limit}] = reviews[cardID]
+				}
+			}
+			return results, nil
+		}),
+	}
+}
+
+func withLoaders(ctx context.Context, l *loaders) context.Context {
+	return context.WithValue(ctx, loadersKey{}, l)
+}
+
+func loadersFor(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
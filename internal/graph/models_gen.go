@@ -0,0 +1,96 @@
+// Code generated by github.com/99designs/gqlgen, DO NOT EDIT.
+
+package graph
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+)
+
+type CardConnection struct {
+	Edges    []*CardEdge `json:"edges"`
+	PageInfo *PageInfo   `json:"pageInfo"`
+	// The number of matching cards. Counting reads every match, so only ask for it when needed.
+	TotalCount int `json:"totalCount"`
+}
+
+type CardEdge struct {
+	Cursor string     `json:"cursor"`
+	Node   *data.Card `json:"node"`
+}
+
+type PageInfo struct {
+	HasNextPage     bool    `json:"hasNextPage"`
+	HasPreviousPage bool    `json:"hasPreviousPage"`
+	StartCursor     *string `json:"startCursor,omitempty"`
+	EndCursor       *string `json:"endCursor,omitempty"`
+}
+
+type Query struct {
+}
+
+type ReviewConnection struct {
+	Edges    []*ReviewEdge `json:"edges"`
+	PageInfo *PageInfo     `json:"pageInfo"`
+}
+
+type ReviewEdge struct {
+	Cursor string       `json:"cursor"`
+	Node   *data.Review `json:"node"`
+}
+
+type CardSort string
+
+const (
+	CardSortID                 CardSort = "ID"
+	CardSortTitle              CardSort = "TITLE"
+	CardSortCreatedAt          CardSort = "CREATED_AT"
+	CardSortNextReviewDate     CardSort = "NEXT_REVIEW_DATE"
+	CardSortIDDesc             CardSort = "ID_DESC"
+	CardSortTitleDesc          CardSort = "TITLE_DESC"
+	CardSortCreatedAtDesc      CardSort = "CREATED_AT_DESC"
+	CardSortNextReviewDateDesc CardSort = "NEXT_REVIEW_DATE_DESC"
+)
+
+var AllCardSort = []CardSort{
+	CardSortID,
+	CardSortTitle,
+	CardSortCreatedAt,
+	CardSortNextReviewDate,
+	CardSortIDDesc,
+	CardSortTitleDesc,
+	CardSortCreatedAtDesc,
+	CardSortNextReviewDateDesc,
+}
+
+func (e CardSort) IsValid() bool {
+	switch e {
+	case CardSortID, CardSortTitle, CardSortCreatedAt, CardSortNextReviewDate, CardSortIDDesc, CardSortTitleDesc, CardSortCreatedAtDesc, CardSortNextReviewDateDesc:
+		return true
+	}
+	return false
+}
+
+func (e CardSort) String() string {
+	return string(e)
+}
+
+func (e *CardSort) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = CardSort(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid CardSort", str)
+	}
+	return nil
+}
+
+func (e CardSort) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
@@ -0,0 +1,136 @@
+package graph
+
+// THIS CODE IS A STARTING POINT ONLY. IT WILL NOT BE UPDATED WITH SCHEMA CHANGES.
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vynquoc/cs-flash-cards/internal/data"
+	"github.com/vynquoc/cs-flash-cards/internal/validator"
+)
+
+// Resolver resolves operations against the same models as the REST API.
+type Resolver struct {
+	Models data.Models
+}
+
+// CodeSnippet is the resolver for the codeSnippet field.
+func (r *cardResolver) CodeSnippet(ctx context.Context, obj *data.Card) (map[string]interface{}, error) {
+	if obj.CodeSnippet == nil {
+		return nil, nil
+	}
+	return *obj.CodeSnippet, nil
+}
+
+// Reviews is the resolver for the reviews field.
+func (r *cardResolver) Reviews(ctx context.Context, obj *data.Card, first *int, after *string) (*ReviewConnection, error) {
+	v := validator.New()
+	limit := validatePageSize(v, first)
+	var cur reviewCursor
+	if after != nil {
+		var err error
+		cur, err = decodeReviewCursor(*after)
+		v.Check(err == nil, "after", "invalid cursor value")
+	}
+	if !v.Valid() {
+		return nil, validationError(v)
+	}
+
+	// One more than asked for shows whether another page follows.
+	reviews, err := loadersFor(ctx).reviews.Load(reviewsKey{cardID: obj.ID, after: cur, limit: limit + 1})
+	if err != nil {
+		return nil, err
+	}
+	conn := &ReviewConnection{Edges: []*ReviewEdge{}, PageInfo: &PageInfo{HasPreviousPage: after != nil}}
+	if len(reviews) > limit {
+		reviews = reviews[:limit]
+		conn.PageInfo.HasNextPage = true
+	}
+	for _, review := range reviews {
+		conn.Edges = append(conn.Edges, &ReviewEdge{Cursor: reviewCursorFor(review).encode(), Node: review})
+	}
+	if len(conn.Edges) > 0 {
+		conn.PageInfo.StartCursor = &conn.Edges[0].Cursor
+		conn.PageInfo.EndCursor = &conn.Edges[len(conn.Edges)-1].Cursor
+	}
+	return conn, nil
+}
+
+// Card is the resolver for the card field.
+func (r *queryResolver) Card(ctx context.Context, id int64) (*data.Card, error) {
+	return loadersFor(ctx).cards.Load(id)
+}
+
+// Cards is the resolver for the cards field.
+func (r *queryResolver) Cards(ctx context.Context, first *int, after *string, title *string, tags []string, sort *CardSort) (*CardConnection, error) {
+	v := validator.New()
+	filters := data.Filters{
+		Page:         1,
+		PageSize:     validatePageSize(v, first),
+		Sort:         cardSorts[CardSortCreatedAt],
+		SortSafeList: []string{"id", "title", "created_at", "next_review_date", "-id", "-title", "-created_at", "-next_review_date"},
+		Count:        data.CountNone,
+	}
+	if sort != nil {
+		filters.Sort = cardSorts[*sort]
+	}
+	if after != nil {
+		filters.Cursor = *after
+	}
+	// Counting reads every matching card, so it is only done when asked for.
+	for _, field := range graphql.CollectAllFields(ctx) {
+		if field == "totalCount" {
+			filters.Count = data.CountExact
+		}
+	}
+	if validateFilters(v, filters); !v.Valid() {
+		return nil, validationError(v)
+	}
+	if title == nil {
+		title = new(string)
+	}
+
+	cards, metadata, err := r.Models.Cards.GetAll(*title, tags, filters, nil)
+	if err != nil {
+		return nil, err
+	}
+	conn := &CardConnection{
+		Edges:      []*CardEdge{},
+		PageInfo:   &PageInfo{HasNextPage: metadata.NextCursor != "", HasPreviousPage: after != nil},
+		TotalCount: metadata.TotalRecords,
+	}
+	cardLoader := loadersFor(ctx).cards
+	for _, card := range cards {
+		cardLoader.Prime(card.ID, card)
+		conn.Edges = append(conn.Edges, &CardEdge{Cursor: filters.CursorFor(card), Node: card})
+	}
+	if len(conn.Edges) > 0 {
+		conn.PageInfo.StartCursor = &conn.Edges[0].Cursor
+		conn.PageInfo.EndCursor = &conn.Edges[len(conn.Edges)-1].Cursor
+	}
+	return conn, nil
+}
+
+// Tags is the resolver for the tags field.
+func (r *queryResolver) Tags(ctx context.Context) ([]*data.TagNode, error) {
+	return r.Models.Tags.GetTree()
+}
+
+// Card is the resolver for the card field.
+func (r *reviewResolver) Card(ctx context.Context, obj *data.Review) (*data.Card, error) {
+	return loadersFor(ctx).cards.Load(obj.CardID)
+}
+
+// Card returns CardResolver implementation.
+func (r *Resolver) Card() CardResolver { return &cardResolver{r} }
+
+// Query returns QueryResolver implementation.
+func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
+
+// Review returns ReviewResolver implementation.
+func (r *Resolver) Review() ReviewResolver { return &reviewResolver{r} }
+
+type cardResolver struct{ *Resolver }
+type queryResolver struct{ *Resolver }
+type reviewResolver struct{ *Resolver }
@@ -0,0 +1,44 @@
+package graph
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// Int64ID is the ID scalar. Ids are bigints in the database and are sent as
+// strings, as the GraphQL spec asks for IDs, but accepted as either.
+type Int64ID = int64
+
+func MarshalInt64ID(id int64) graphql.Marshaler {
+	return graphql.WriterFunc(func(w io.Writer) {
+		io.WriteString(w, strconv.Quote(strconv.FormatInt(id, 10)))
+	})
+}
+
+// UnmarshalInt64ID reports a malformed id as a gqlerror, which the error
+// presenter passes on to the client, rather than as an internal error.
+func UnmarshalInt64ID(v interface{}) (int64, error) {
+	var id int64
+	var err error
+	switch v := v.(type) {
+	case string:
+		id, err = strconv.ParseInt(v, 10, 64)
+	case json.Number:
+		id, err = v.Int64()
+	case int:
+		id = int64(v)
+	case int64:
+		id = v
+	default:
+		err = errors.New("unsupported type")
+	}
+	if err != nil {
+		return 0, gqlerror.Errorf("%v is not a valid id", v)
+	}
+	return id, nil
+}
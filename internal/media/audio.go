@@ -0,0 +1,433 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	TypeMP3 = "audio/mpeg"
+	TypeOGG = "audio/ogg"
+	TypeM4A = "audio/mp4"
+)
+
+var ErrCorruptAudio = errors.New("corrupt or unsupported audio file")
+
+// maxAudioDuration bounds the durations read from headers. Anything longer
+// comes from a corrupt or crafted file, and could overflow time.Duration.
+const maxAudioDuration = 100 * time.Hour
+
+// ticksDuration converts a count of ticks at rate ticks per second, such as
+// samples or bits, into a duration without overflowing.
+func ticksDuration(ticks, rate uint64) (time.Duration, error) {
+	if rate == 0 {
+		return 0, ErrCorruptAudio
+	}
+	seconds := ticks / rate
+	if seconds > uint64(maxAudioDuration/time.Second) {
+		return 0, ErrCorruptAudio
+	}
+	fraction := time.Duration((ticks % rate) * uint64(time.Second) / rate)
+	return time.Duration(seconds)*time.Second + fraction, nil
+}
+
+// DetectAudioType identifies an MP3, Ogg or MP4 audio file from its leading
+// bytes. Files that only look right are caught by AudioDuration, which has to
+// parse them fully.
+func DetectAudioType(head []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(head, []byte("ID3")):
+		return TypeMP3, nil
+	case len(head) >= 4 && isMP3FrameHeader(head):
+		return TypeMP3, nil
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return TypeOGG, nil
+	case len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")):
+		return TypeM4A, nil
+	}
+	return "", ErrUnsupportedType
+}
+
+// AudioDuration returns the playing time of an MP3, an Ogg Vorbis or Opus
+// file, or an MP4 file with an audio track and no video. Only the headers
+// are read from src, and the end of the file for Ogg.
+func AudioDuration(contentType string, src io.ReadSeeker) (time.Duration, error) {
+	if contentType != TypeMP3 && contentType != TypeOGG && contentType != TypeM4A {
+		return 0, ErrUnsupportedType
+	}
+	size, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	switch contentType {
+	case TypeMP3:
+		return mp3Duration(src, size)
+	case TypeOGG:
+		return oggDuration(src, size)
+	}
+	return mp4Duration(src, size)
+}
+
+// readAt reads up to n bytes at offset off. It returns fewer only if the file
+// ends first.
+func readAt(src io.ReadSeeker, off int64, n int) ([]byte, error) {
+	_, err := src.Seek(off, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	read, err := io.ReadFull(src, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = nil
+	}
+	return buf[:read], err
+}
+
+// Limits on how much of a file is read to find its duration.
+const (
+	// mp3ScanLen is how far past the ID3 tag the first frame is looked for.
+	mp3ScanLen = 64 << 10
+	// oggScanLen is the size of the windows read backwards from the end of an
+	// Ogg file to find its last page.
+	oggScanLen = 64 << 10
+	// maxMoovSize bounds the MP4 movie box, which is read into memory.
+	maxMoovSize = 64 << 20
+)
+
+// mp3Bitrates are in kbit/s, indexed by [MPEG-1 or not][layer - 1][index].
+var mp3Bitrates = [2][3][16]int{
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+}
+
+// mp3SampleRates are in Hz, indexed by the version bits and then the index.
+var mp3SampleRates = [4][3]int{
+	{11025, 12000, 8000},  // MPEG-2.5
+	{},                    // reserved
+	{22050, 24000, 16000}, // MPEG-2
+	{44100, 48000, 32000}, // MPEG-1
+}
+
+type mp3Frame struct {
+	mpeg1      bool
+	layer      int
+	bitrate    int
+	sampleRate int
+	mono       bool
+}
+
+func isMP3FrameHeader(b []byte) bool {
+	_, ok := parseMP3FrameHeader(b)
+	return ok
+}
+
+func parseMP3FrameHeader(b []byte) (mp3Frame, bool) {
+	if len(b) < 4 || b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return mp3Frame{}, false
+	}
+	version := int(b[1]>>3) & 3
+	layer := 4 - int(b[1]>>1)&3
+	bitrateIndex := int(b[2] >> 4)
+	rateIndex := int(b[2]>>2) & 3
+	if version == 1 || layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return mp3Frame{}, false
+	}
+
+	frame := mp3Frame{mpeg1: version == 3, layer: layer, mono: b[3]>>6 == 3}
+	table := 1
+	if frame.mpeg1 {
+		table = 0
+	}
+	frame.bitrate = mp3Bitrates[table][layer-1][bitrateIndex] * 1000
+	frame.sampleRate = mp3SampleRates[version][rateIndex]
+	return frame, true
+}
+
+func (f mp3Frame) samples() int {
+	switch {
+	case f.layer == 1:
+		return 384
+	case f.layer == 3 && !f.mpeg1:
+		return 576
+	}
+	return 1152
+}
+
+// mp3Duration reads the frame count from a Xing, Info or VBRI header when
+// there is one, as VBR files need it. Otherwise the file is assumed to be
+// constant bitrate.
+func mp3Duration(src io.ReadSeeker, size int64) (time.Duration, error) {
+	header, err := readAt(src, 0, 10)
+	if err != nil {
+		return 0, err
+	}
+	start := int64(0)
+	if bytes.HasPrefix(header, []byte("ID3")) && len(header) >= 10 {
+		tagSize := int64(header[6]&0x7f)<<21 | int64(header[7]&0x7f)<<14 | int64(header[8]&0x7f)<<7 | int64(header[9]&0x7f)
+		start = 10 + tagSize
+		if header[5]&0x10 != 0 {
+			start += 10
+		}
+		// A tag size past the end of the file means it isn't really an MP3.
+		if start >= size {
+			return 0, ErrCorruptAudio
+		}
+	}
+	end := size
+	if end-128 >= start {
+		trailer, err := readAt(src, end-128, 3)
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Equal(trailer, []byte("TAG")) {
+			end -= 128
+		}
+	}
+
+	window, err := readAt(src, start, int(min(end-start, mp3ScanLen)))
+	if err != nil {
+		return 0, err
+	}
+	// Skip any padding between the tag and the first frame.
+	offset := 0
+	for offset+4 <= len(window) && !isMP3FrameHeader(window[offset:]) {
+		offset++
+	}
+	frame, ok := parseMP3FrameHeader(window[offset:])
+	if !ok {
+		return 0, ErrCorruptAudio
+	}
+
+	sideInfo := 17
+	switch {
+	case frame.mpeg1 && !frame.mono:
+		sideInfo = 32
+	case !frame.mpeg1 && frame.mono:
+		sideInfo = 9
+	}
+	xing := offset + 4 + sideInfo
+	vbri := offset + 4 + 32
+
+	frames := 0
+	switch {
+	case xing+12 <= len(window) && (bytes.HasPrefix(window[xing:], []byte("Xing")) || bytes.HasPrefix(window[xing:], []byte("Info"))):
+		if binary.BigEndian.Uint32(window[xing+4:])&1 != 0 {
+			frames = int(binary.BigEndian.Uint32(window[xing+8:]))
+		}
+	case vbri+18 <= len(window) && bytes.HasPrefix(window[vbri:], []byte("VBRI")):
+		frames = int(binary.BigEndian.Uint32(window[vbri+14:]))
+	}
+
+	if frames > 0 {
+		return ticksDuration(uint64(frames)*uint64(frame.samples()), uint64(frame.sampleRate))
+	}
+	return ticksDuration(uint64(end-start-int64(offset))*8, uint64(frame.bitrate))
+}
+
+// oggDuration divides the granule position of the last page, which counts
+// samples, by the sample rate from the codec's identification header.
+func oggDuration(src io.ReadSeeker, size int64) (time.Duration, error) {
+	// The first page holds only the identification header, which is short.
+	first, err := readAt(src, 0, 27+255+64)
+	if err != nil {
+		return 0, err
+	}
+	if len(first) < 28 || !bytes.HasPrefix(first, []byte("OggS")) {
+		return 0, ErrCorruptAudio
+	}
+	serial := binary.LittleEndian.Uint32(first[14:])
+	payload := 27 + int(first[26])
+	if payload > len(first) {
+		return 0, ErrCorruptAudio
+	}
+	ident := first[payload:]
+
+	var rate, preSkip int64
+	switch {
+	case len(ident) >= 16 && bytes.HasPrefix(ident, []byte("\x01vorbis")):
+		rate = int64(binary.LittleEndian.Uint32(ident[12:]))
+	case len(ident) >= 12 && bytes.HasPrefix(ident, []byte("OpusHead")):
+		// Opus granule positions always count 48 kHz samples.
+		rate = 48000
+		preSkip = int64(binary.LittleEndian.Uint16(ident[10:]))
+	default:
+		return 0, ErrCorruptAudio
+	}
+	if rate == 0 {
+		return 0, ErrCorruptAudio
+	}
+
+	// Windows overlap by a page header, so that a header split between two
+	// windows is whole in the later one.
+	for hi := size; hi > 0; {
+		lo := max(hi-oggScanLen, 0)
+		window, err := readAt(src, lo, int(hi-lo))
+		if err != nil {
+			return 0, err
+		}
+		for i := bytes.LastIndex(window, []byte("OggS")); i >= 0; i = bytes.LastIndex(window[:i], []byte("OggS")) {
+			if i+27 > len(window) || binary.LittleEndian.Uint32(window[i+14:]) != serial {
+				continue
+			}
+			granule := int64(binary.LittleEndian.Uint64(window[i+6:]))
+			if granule < 0 {
+				continue
+			}
+			samples := max(granule-preSkip, 0)
+			return ticksDuration(uint64(samples), uint64(rate))
+		}
+		if lo == 0 {
+			break
+		}
+		hi = lo + 27
+	}
+	return 0, ErrCorruptAudio
+}
+
+// mp4Boxes calls fn with the type and payload of each box in src.
+func mp4Boxes(src []byte, fn func(boxType string, payload []byte) error) error {
+	for len(src) > 0 {
+		if len(src) < 8 {
+			return ErrCorruptAudio
+		}
+		size := uint64(binary.BigEndian.Uint32(src))
+		boxType := string(src[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(src))
+		case 1:
+			if len(src) < 16 {
+				return ErrCorruptAudio
+			}
+			size = binary.BigEndian.Uint64(src[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(src)) {
+			return ErrCorruptAudio
+		}
+		err := fn(boxType, src[header:size])
+		if err != nil {
+			return err
+		}
+		src = src[size:]
+	}
+	return nil
+}
+
+// mp4Duration reads the duration from the movie header, after checking that
+// the file has a sound track and no video track. Top-level boxes other than
+// the movie box are skipped over without being read.
+func mp4Duration(src io.ReadSeeker, size int64) (time.Duration, error) {
+	var duration time.Duration
+	var foundHeader, hasSound, hasVideo bool
+
+	for offset := int64(0); offset < size; {
+		header, err := readAt(src, offset, 16)
+		if err != nil {
+			return 0, err
+		}
+		if len(header) < 8 {
+			return 0, ErrCorruptAudio
+		}
+		boxSize := uint64(binary.BigEndian.Uint32(header))
+		headerLen := uint64(8)
+		switch boxSize {
+		case 0:
+			boxSize = uint64(size - offset)
+		case 1:
+			if len(header) < 16 {
+				return 0, ErrCorruptAudio
+			}
+			boxSize = binary.BigEndian.Uint64(header[8:])
+			headerLen = 16
+		}
+		if boxSize < headerLen || boxSize > uint64(size-offset) {
+			return 0, ErrCorruptAudio
+		}
+
+		if string(header[4:8]) == "moov" {
+			if boxSize-headerLen > maxMoovSize {
+				return 0, ErrCorruptAudio
+			}
+			moov, err := readAt(src, offset+int64(headerLen), int(boxSize-headerLen))
+			if err != nil {
+				return 0, err
+			}
+			err = mp4Boxes(moov, func(boxType string, payload []byte) error {
+				switch boxType {
+				case "mvhd":
+					d, err := mvhdDuration(payload)
+					if err != nil {
+						return err
+					}
+					duration, foundHeader = d, true
+				case "trak":
+					handler, err := mp4TrackHandler(payload)
+					if err != nil {
+						return err
+					}
+					hasSound = hasSound || handler == "soun"
+					hasVideo = hasVideo || handler == "vide"
+				}
+				return nil
+			})
+			if err != nil {
+				return 0, err
+			}
+		}
+		offset += int64(boxSize)
+	}
+
+	if !foundHeader || !hasSound || hasVideo {
+		return 0, ErrCorruptAudio
+	}
+	return duration, nil
+}
+
+func mvhdDuration(mvhd []byte) (time.Duration, error) {
+	if len(mvhd) < 20 {
+		return 0, ErrCorruptAudio
+	}
+	var timescale, duration uint64
+	if mvhd[0] == 1 {
+		if len(mvhd) < 32 {
+			return 0, ErrCorruptAudio
+		}
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[20:]))
+		duration = binary.BigEndian.Uint64(mvhd[24:])
+	} else {
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[12:]))
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:]))
+	}
+	return ticksDuration(duration, timescale)
+}
+
+// mp4TrackHandler returns the handler type of a track, such as "soun" or
+// "vide", from trak/mdia/hdlr.
+func mp4TrackHandler(trak []byte) (string, error) {
+	handler := ""
+	err := mp4Boxes(trak, func(boxType string, mdia []byte) error {
+		if boxType != "mdia" {
+			return nil
+		}
+		return mp4Boxes(mdia, func(boxType string, hdlr []byte) error {
+			if boxType == "hdlr" && len(hdlr) >= 12 {
+				handler = string(hdlr[8:12])
+			}
+			return nil
+		})
+	})
+	return handler, err
+}
@@ -0,0 +1,216 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// mp3FrameHeader is MPEG-1 Layer III, 128 kbit/s, 44.1 kHz, stereo. Frames
+// of this kind are 417 bytes long.
+var mp3FrameHeader = []byte{0xff, 0xfb, 0x90, 0x00}
+
+const mp3FrameLen = 417
+
+// cbrMP3 returns n constant bitrate frames.
+func cbrMP3(n int) []byte {
+	frame := make([]byte, mp3FrameLen)
+	copy(frame, mp3FrameHeader)
+	return bytes.Repeat(frame, n)
+}
+
+// xingMP3 returns a file whose first frame holds a Xing header giving the
+// frame count, followed by one more frame.
+func xingMP3(frames uint32) []byte {
+	src := cbrMP3(2)
+	xing := 4 + 32
+	copy(src[xing:], "Xing")
+	binary.BigEndian.PutUint32(src[xing+4:], 1)
+	binary.BigEndian.PutUint32(src[xing+8:], frames)
+	return src
+}
+
+// id3Tag returns an ID3v2 header declaring a tag of the given size, followed
+// by that many zero bytes.
+func id3Tag(size int) []byte {
+	tag := []byte{'I', 'D', '3', 4, 0, 0,
+		byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	return append(tag, make([]byte, size)...)
+}
+
+// oggPage returns an Ogg page with a single segment of payload.
+func oggPage(granule uint64, serial uint32, payload []byte) []byte {
+	page := make([]byte, 27, 28+len(payload))
+	copy(page, "OggS")
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	binary.LittleEndian.PutUint32(page[14:], serial)
+	page[26] = 1
+	page = append(page, byte(len(payload)))
+	return append(page, payload...)
+}
+
+func vorbisIdent(rate uint32) []byte {
+	ident := make([]byte, 30)
+	copy(ident, "\x01vorbis")
+	ident[11] = 2
+	binary.LittleEndian.PutUint32(ident[12:], rate)
+	return ident
+}
+
+func vorbisOgg(rate uint32, samples uint64) []byte {
+	src := oggPage(0, 7, vorbisIdent(rate))
+	// A page from another logical stream must not be mistaken for the end.
+	src = append(src, oggPage(samples, 7, []byte("audio"))...)
+	return append(src, oggPage(samples*10, 8, []byte("other"))...)
+}
+
+func opusOgg(preSkip uint16, samples uint64) []byte {
+	ident := make([]byte, 19)
+	copy(ident, "OpusHead")
+	ident[8] = 1
+	ident[9] = 2
+	binary.LittleEndian.PutUint16(ident[10:], preSkip)
+	src := oggPage(0, 1, ident)
+	return append(src, oggPage(samples, 1, []byte("audio"))...)
+}
+
+// splitOgg returns a file whose last page has its header split between the
+// last two windows read by oggDuration, with the final window starting ten
+// bytes into it.
+func splitOgg(rate uint32, samples uint64) []byte {
+	src := append(oggPage(0, 7, vorbisIdent(rate)), make([]byte, oggScanLen)...)
+	last := oggPage(samples, 7, []byte("audio"))
+	src = append(src, last...)
+	return append(src, make([]byte, oggScanLen-len(last)+10)...)
+}
+
+func mp4Box(boxType string, payloads ...[]byte) []byte {
+	box := make([]byte, 8)
+	copy(box[4:], boxType)
+	for _, payload := range payloads {
+		box = append(box, payload...)
+	}
+	binary.BigEndian.PutUint32(box, uint32(len(box)))
+	return box
+}
+
+func mp4Track(handler string) []byte {
+	hdlr := make([]byte, 24)
+	copy(hdlr[8:], handler)
+	return mp4Box("trak", mp4Box("mdia", mp4Box("hdlr", hdlr)))
+}
+
+func m4a(timescale, duration uint32, tracks ...[]byte) []byte {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], timescale)
+	binary.BigEndian.PutUint32(mvhd[16:], duration)
+	moov := mp4Box("moov", append([][]byte{mp4Box("mvhd", mvhd)}, tracks...)...)
+	return append(mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00")), moov...)
+}
+
+func TestDetectAudioType(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{"mp3 frame", cbrMP3(1), TypeMP3},
+		{"id3", id3Tag(10), TypeMP3},
+		{"ogg", vorbisOgg(44100, 44100), TypeOGG},
+		{"m4a", m4a(1000, 1000, mp4Track("soun")), TypeM4A},
+		{"png", []byte("\x89PNG\r\n\x1a\n"), ""},
+		{"empty", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DetectAudioType(tt.head)
+			if tt.want == "" {
+				if !errors.Is(err, ErrUnsupportedType) {
+					t.Errorf("got %q, %v; want ErrUnsupportedType", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("got %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAudioDuration(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		src         []byte
+		want        time.Duration
+		wantErr     bool
+	}{
+		{"cbr mp3", TypeMP3, cbrMP3(100), 100 * mp3FrameLen * 8 * time.Second / 128000, false},
+		{"cbr mp3 with id3 tag", TypeMP3, append(id3Tag(100), cbrMP3(100)...), 100 * mp3FrameLen * 8 * time.Second / 128000, false},
+		{"xing mp3", TypeMP3, xingMP3(1000), 1000 * 1152 * time.Second / 44100, false},
+		{"xing frame count too large", TypeMP3, xingMP3(0xffffffff), 0, true},
+		{"id3 tag larger than file", TypeMP3, []byte("ID3\x04\x00\x00\x7f\x7f\x7f\x7f" + "\xff\xfb\x90\x00"), 0, true},
+		{"id3 tag with no frames", TypeMP3, id3Tag(20), 0, true},
+		{"mp3 without frames", TypeMP3, []byte("not an mp3 file"), 0, true},
+		{"vorbis", TypeOGG, vorbisOgg(44100, 441000), 10 * time.Second, false},
+		{"opus", TypeOGG, opusOgg(312, 48000*3+312), 3 * time.Second, false},
+		{"ogg page split between windows", TypeOGG, splitOgg(44100, 441000), 10 * time.Second, false},
+		{"ogg without ident header", TypeOGG, oggPage(0, 1, []byte("nothing")), 0, true},
+		{"ogg granule too large", TypeOGG, vorbisOgg(1, 1<<62), 0, true},
+		{"ogg with zero rate", TypeOGG, vorbisOgg(0, 100), 0, true},
+		{"truncated ogg", TypeOGG, vorbisOgg(44100, 441000)[:30], 0, true},
+		{"m4a", TypeM4A, m4a(1000, 65432, mp4Track("soun")), 65432 * time.Millisecond, false},
+		{"mp4 with video", TypeM4A, m4a(1000, 65432, mp4Track("soun"), mp4Track("vide")), 0, true},
+		{"mp4 without sound", TypeM4A, m4a(1000, 65432), 0, true},
+		{"mp4 with zero timescale", TypeM4A, m4a(0, 65432, mp4Track("soun")), 0, true},
+		{"mp4 box larger than file", TypeM4A, []byte("\x00\x00\x10\x00moov"), 0, true},
+		{"unsupported", TypePNG, nil, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AudioDuration(tt.contentType, bytes.NewReader(tt.src))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %v; want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fuzzAudioDuration checks that a parser never panics and never returns a
+// negative duration.
+func fuzzAudioDuration(f *testing.F, contentType string, seeds ...[]byte) {
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, src []byte) {
+		d, err := AudioDuration(contentType, bytes.NewReader(src))
+		if err == nil && d < 0 {
+			t.Errorf("got negative duration %v", d)
+		}
+	})
+}
+
+func FuzzMP3Duration(f *testing.F) {
+	fuzzAudioDuration(f, TypeMP3, cbrMP3(3), xingMP3(10), append(id3Tag(4), cbrMP3(1)...))
+}
+
+func FuzzOggDuration(f *testing.F) {
+	fuzzAudioDuration(f, TypeOGG, vorbisOgg(44100, 1000), opusOgg(312, 5000))
+}
+
+func FuzzMP4Duration(f *testing.F) {
+	fuzzAudioDuration(f, TypeM4A, m4a(1000, 5000, mp4Track("soun")))
+}
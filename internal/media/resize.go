@@ -0,0 +1,167 @@
+package media
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// MaxDecodePixels guards against decompression bombs: images with more
+// pixels than this are stored but not decoded to build variants.
+const MaxDecodePixels = 50_000_000
+
+var ErrImageTooLarge = errors.New("image too large to decode")
+
+// Decode decodes a PNG, JPEG or GIF (first frame only) read from the start of
+// src, after checking its dimensions against MaxDecodePixels. Only the header
+// is read before the check, so oversized images are never buffered.
+func Decode(contentType string, src io.ReadSeeker) (image.Image, error) {
+	var decodeConfig func(io.Reader) (image.Config, error)
+	var decode func(io.Reader) (image.Image, error)
+	switch contentType {
+	case TypePNG:
+		decodeConfig, decode = png.DecodeConfig, png.Decode
+	case TypeJPEG:
+		decodeConfig, decode = jpeg.DecodeConfig, jpeg.Decode
+	case TypeGIF:
+		decodeConfig, decode = gif.DecodeConfig, gif.Decode
+	default:
+		return nil, ErrUnsupportedType
+	}
+
+	_, err := src.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := decodeConfig(bufio.NewReader(src))
+	if err != nil {
+		return nil, ErrCorruptImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxDecodePixels {
+		return nil, ErrImageTooLarge
+	}
+
+	_, err = src.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	img, err := decode(bufio.NewReader(src))
+	if err != nil {
+		return nil, ErrCorruptImage
+	}
+	return img, nil
+}
+
+// Encode writes img to dst as a JPEG for photos and a PNG for everything
+// else, which keeps transparency and sharp edges in screenshots and diagrams.
+// It returns the type it wrote.
+func Encode(dst io.Writer, img image.Image, sourceType string) (string, error) {
+	if sourceType == TypeJPEG {
+		return TypeJPEG, jpeg.Encode(dst, img, &jpeg.Options{Quality: 85})
+	}
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	return TypePNG, enc.Encode(dst, img)
+}
+
+// Orient applies an EXIF orientation, as returned by JPEGOrientation, so the
+// image displays the right way up once the EXIF data has been removed.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	src := toRGBA(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	// Orientations 5 to 8 swap width and height.
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dy*dst.Stride+dx*4:dy*dst.Stride+dx*4+4], src.Pix[y*src.Stride+x*4:])
+		}
+	}
+	return dst
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
+
+// Fit returns the size of an image scaled down to fit within maxSize on its
+// longest side, keeping the aspect ratio. Images that already fit keep their
+// size.
+func Fit(width, height, maxSize int) (int, int) {
+	if width <= maxSize && height <= maxSize {
+		return width, height
+	}
+	if width >= height {
+		return maxSize, max(1, height*maxSize/width)
+	}
+	return max(1, width*maxSize/height), maxSize
+}
+
+// Resize scales img down to width x height by averaging the source pixels
+// that fall in each destination pixel. It is meant for downscaling only.
+func Resize(img image.Image, width, height int) image.Image {
+	src := toRGBA(img)
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for dy := 0; dy < height; dy++ {
+		y0 := dy * sh / height
+		y1 := max(y0+1, (dy+1)*sh/height)
+		for dx := 0; dx < width; dx++ {
+			x0 := dx * sw / width
+			x1 := max(x0+1, (dx+1)*sw/width)
+
+			var r, g, bl, a, n uint64
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride+x0*4 : y*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					r += uint64(row[i])
+					g += uint64(row[i+1])
+					bl += uint64(row[i+2])
+					a += uint64(row[i+3])
+					n++
+				}
+			}
+			i := dy*dst.Stride + dx*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(bl / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,96 @@
+package media
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		name                  string
+		width, height, max    int
+		wantWidth, wantHeight int
+	}{
+		{"already fits", 800, 600, 1024, 800, 600},
+		{"exactly fits", 1024, 1024, 1024, 1024, 1024},
+		{"landscape", 4000, 3000, 1024, 1024, 768},
+		{"portrait", 3000, 4000, 1024, 768, 1024},
+		{"square", 2048, 2048, 1024, 1024, 1024},
+		{"rounds down", 1000, 333, 500, 500, 166},
+		{"thin strip keeps a pixel", 10000, 2, 100, 100, 1},
+		{"tall strip keeps a pixel", 2, 10000, 100, 1, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h := Fit(tt.width, tt.height, tt.max)
+			if w != tt.wantWidth || h != tt.wantHeight {
+				t.Errorf("Fit(%d, %d, %d) = %d, %d; want %d, %d", tt.width, tt.height, tt.max, w, h, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func fill(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	transparent := color.RGBA{}
+
+	t.Run("averages each block", func(t *testing.T) {
+		src := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		fill(src, image.Rect(0, 0, 2, 4), red)
+		fill(src, image.Rect(2, 0, 4, 4), blue)
+		// The bottom right block is half blue and half transparent.
+		fill(src, image.Rect(2, 3, 4, 4), transparent)
+
+		dst := Resize(src, 2, 2).(*image.RGBA)
+		if got := dst.Bounds(); got != image.Rect(0, 0, 2, 2) {
+			t.Fatalf("bounds %v; want 2x2", got)
+		}
+		want := map[image.Point]color.RGBA{
+			{0, 0}: red,
+			{0, 1}: red,
+			{1, 0}: blue,
+			{1, 1}: {0, 0, 127, 127},
+		}
+		for p, c := range want {
+			if got := dst.RGBAAt(p.X, p.Y); got != c {
+				t.Errorf("pixel %v = %v; want %v", p, got, c)
+			}
+		}
+	})
+
+	t.Run("uneven scale covers every destination pixel", func(t *testing.T) {
+		src := image.NewRGBA(image.Rect(0, 0, 5, 3))
+		fill(src, src.Bounds(), red)
+		dst := Resize(src, 2, 2).(*image.RGBA)
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 2; x++ {
+				if got := dst.RGBAAt(x, y); got != red {
+					t.Errorf("pixel (%d, %d) = %v; want %v", x, y, got, red)
+				}
+			}
+		}
+	})
+
+	t.Run("source with offset bounds", func(t *testing.T) {
+		src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+		fill(src, image.Rect(0, 0, 2, 2), red)
+		fill(src, image.Rect(2, 0, 4, 2), blue)
+		sub := src.SubImage(image.Rect(2, 0, 4, 2))
+
+		dst := Resize(sub, 1, 1).(*image.RGBA)
+		if got := dst.RGBAAt(0, 0); got != blue {
+			t.Errorf("pixel = %v; want %v", got, blue)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+// Package media inspects and transforms uploaded files: it identifies them
+// from their content, never from the name or type the client sent.
+package media
+
+import (
+	"bytes"
+	"errors"
+)
+
+const (
+	TypePNG  = "image/png"
+	TypeJPEG = "image/jpeg"
+	TypeGIF  = "image/gif"
+	TypeWebP = "image/webp"
+	TypeSVG  = "image/svg+xml"
+)
+
+var ErrUnsupportedType = errors.New("unsupported file type")
+
+var extensions = map[string]string{
+	TypePNG:  ".png",
+	TypeJPEG: ".jpg",
+	TypeGIF:  ".gif",
+	TypeWebP: ".webp",
+	TypeSVG:  ".svg",
+	TypeMP3:  ".mp3",
+	TypeOGG:  ".ogg",
+	TypeM4A:  ".m4a",
+}
+
+// Extension returns the file extension used for a detected content type.
+func Extension(contentType string) string {
+	return extensions[contentType]
+}
+
+// SniffLen is how many leading bytes DetectImageType needs.
+const SniffLen = 512
+
+// DetectImageType identifies an image from its leading bytes. SVGs are only
+// recognised loosely here; SanitizeSVG does the real parsing.
+func DetectImageType(head []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return TypePNG, nil
+	case bytes.HasPrefix(head, []byte("\xff\xd8\xff")):
+		return TypeJPEG, nil
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return TypeGIF, nil
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP")):
+		return TypeWebP, nil
+	case looksLikeSVG(head):
+		return TypeSVG, nil
+	}
+	return "", ErrUnsupportedType
+}
+
+func looksLikeSVG(head []byte) bool {
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	head = bytes.TrimLeft(head, " \t\r\n")
+	return bytes.HasPrefix(head, []byte("<")) && bytes.Contains(bytes.ToLower(head), []byte("<svg"))
+}
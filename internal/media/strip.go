@@ -0,0 +1,371 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var ErrCorruptImage = errors.New("corrupt image")
+
+// StripMetadata copies an image from src to dst without its EXIF, XMP, IPTC
+// and text metadata, which can carry GPS coordinates and device details. The
+// image is not re-encoded and colour profiles are kept. GIFs and SVGs are
+// copied unchanged: GIF has no standard metadata of that kind and SVGs are
+// rebuilt by SanitizeSVG. src is read from the start, and dst may hold a
+// partial image if an error is returned.
+func StripMetadata(dst io.Writer, contentType string, src io.ReadSeeker) error {
+	_, err := src.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	switch contentType {
+	case TypeJPEG:
+		err = stripJPEG(dst, bufio.NewReader(src))
+	case TypePNG:
+		err = stripPNG(dst, bufio.NewReader(src))
+	case TypeWebP:
+		err = stripWebP(dst, src)
+	default:
+		_, err = io.Copy(dst, src)
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrCorruptImage
+	}
+	return err
+}
+
+// copyFull copies exactly n bytes, failing with io.ErrUnexpectedEOF if src
+// ends first.
+func copyFull(dst io.Writer, src io.Reader, n int64) error {
+	copied, err := io.CopyN(dst, src, n)
+	if copied < n && (err == nil || errors.Is(err, io.EOF)) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// stripJPEG drops the APP1 (EXIF, XMP), APP12, APP13 (IPTC) and comment
+// segments that come before the image data.
+func stripJPEG(dst io.Writer, src *bufio.Reader) error {
+	var soi [2]byte
+	_, err := io.ReadFull(src, soi[:])
+	if err != nil || soi[0] != 0xff || soi[1] != 0xd8 {
+		return ErrCorruptImage
+	}
+	_, err = dst.Write(soi[:])
+	if err != nil {
+		return err
+	}
+
+	for {
+		b, err := src.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != 0xff {
+			return ErrCorruptImage
+		}
+		// Markers may be preceded by any number of 0xff fill bytes.
+		marker := byte(0xff)
+		for marker == 0xff {
+			marker, err = src.ReadByte()
+			if err != nil {
+				return err
+			}
+		}
+
+		// Markers without a length: TEM and RST0-7.
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			_, err = dst.Write([]byte{0xff, marker})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		// End of image, or start of scan where the rest is entropy-coded
+		// image data: copy everything that is left.
+		if marker == 0xd9 || marker == 0xda {
+			_, err = dst.Write([]byte{0xff, marker})
+			if err != nil {
+				return err
+			}
+			if marker == 0xda {
+				if _, err := src.Peek(2); err != nil {
+					return err
+				}
+			}
+			_, err = io.Copy(dst, src)
+			return err
+		}
+
+		var length [2]byte
+		_, err = io.ReadFull(src, length[:])
+		if err != nil {
+			return err
+		}
+		// The length counts its own two bytes, so anything shorter is corrupt.
+		segLen := int64(binary.BigEndian.Uint16(length[:]))
+		if segLen < 2 {
+			return ErrCorruptImage
+		}
+
+		switch marker {
+		case 0xe1, 0xec, 0xed, 0xfe:
+			err = copyFull(io.Discard, src, segLen-2)
+		default:
+			_, err = dst.Write([]byte{0xff, marker, length[0], length[1]})
+			if err == nil {
+				err = copyFull(dst, src, segLen-2)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadataChunks are the ancillary chunks dropped from PNGs.
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+func stripPNG(dst io.Writer, src *bufio.Reader) error {
+	signature := make([]byte, len(pngSignature))
+	_, err := io.ReadFull(src, signature)
+	if err != nil || !bytes.Equal(signature, pngSignature) {
+		return ErrCorruptImage
+	}
+	_, err = dst.Write(signature)
+	if err != nil {
+		return err
+	}
+
+	for {
+		var header [8]byte
+		n, err := io.ReadFull(src, header[:])
+		if n == 0 && errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return ErrCorruptImage
+		}
+		length := int64(binary.BigEndian.Uint32(header[:]))
+		chunkType := string(header[4:])
+
+		// The chunk data is followed by a 4-byte CRC.
+		if pngMetadataChunks[chunkType] {
+			err = copyFull(io.Discard, src, length+4)
+		} else {
+			_, err = dst.Write(header[:])
+			if err == nil {
+				err = copyFull(dst, src, length+4)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if chunkType == "IEND" {
+			return nil
+		}
+	}
+}
+
+// VP8X flags for the presence of EXIF and XMP chunks.
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+// webpChunkHeader is the position, type and size of a chunk in a WebP file.
+type webpChunkHeader struct {
+	offset    int64
+	chunkType string
+	size      int64
+	// padded is the size including the pad byte that keeps chunks at even
+	// offsets, which the final chunk may be missing.
+	padded int64
+}
+
+// webpChunks reads the chunk headers of a WebP file, seeking past each
+// chunk's data.
+func webpChunks(src io.ReadSeeker) ([]webpChunkHeader, error) {
+	fileSize, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	_, err = src.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	var header [12]byte
+	_, err = io.ReadFull(src, header[:])
+	if err != nil || string(header[:4]) != "RIFF" || string(header[8:]) != "WEBP" {
+		return nil, ErrCorruptImage
+	}
+
+	var chunks []webpChunkHeader
+	offset := int64(12)
+	for offset < fileSize {
+		if offset+8 > fileSize {
+			return nil, ErrCorruptImage
+		}
+		var chunk [8]byte
+		_, err = io.ReadFull(src, chunk[:])
+		if err != nil {
+			return nil, err
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		padded := size + size%2
+		if offset+8+padded > fileSize {
+			// Tolerate a missing pad byte on the final chunk.
+			if offset+8+size != fileSize {
+				return nil, ErrCorruptImage
+			}
+			padded = size
+		}
+		chunks = append(chunks, webpChunkHeader{offset, string(chunk[:4]), size, padded})
+
+		offset += 8 + padded
+		_, err = src.Seek(offset, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return chunks, nil
+}
+
+// stripWebP makes two passes over the file, as the RIFF header that starts
+// it holds the size of everything after it.
+func stripWebP(dst io.Writer, src io.ReadSeeker) error {
+	chunks, err := webpChunks(src)
+	if err != nil {
+		return err
+	}
+
+	kept := chunks[:0]
+	riffSize := int64(4)
+	for _, chunk := range chunks {
+		if chunk.chunkType == "EXIF" || chunk.chunkType == "XMP " {
+			continue
+		}
+		kept = append(kept, chunk)
+		riffSize += 8 + chunk.padded
+	}
+
+	header := []byte("RIFF\x00\x00\x00\x00WEBP")
+	binary.LittleEndian.PutUint32(header[4:], uint32(riffSize))
+	_, err = dst.Write(header)
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range kept {
+		_, err = src.Seek(chunk.offset, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		if chunk.chunkType == "VP8X" && chunk.size > 0 {
+			var flags [9]byte
+			_, err = io.ReadFull(src, flags[:])
+			if err != nil {
+				return err
+			}
+			flags[8] &^= webpFlagEXIF | webpFlagXMP
+			_, err = dst.Write(flags[:])
+			if err == nil {
+				err = copyFull(dst, src, chunk.padded-1)
+			}
+		} else {
+			err = copyFull(dst, src, 8+chunk.padded)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JPEGOrientation returns the EXIF orientation of a JPEG read from r, from 1
+// to 8, or 1 if it has none. Only the segments before the image data are
+// read.
+func JPEGOrientation(r io.Reader) int {
+	br := bufio.NewReader(r)
+	_, err := br.Discard(2)
+	if err != nil {
+		return 1
+	}
+	for {
+		var header [4]byte
+		_, err := io.ReadFull(br, header[:])
+		if err != nil || header[0] != 0xff {
+			return 1
+		}
+		marker := header[1]
+		// The length counts its own two bytes, so anything shorter is corrupt.
+		segLen := int64(binary.BigEndian.Uint16(header[2:]))
+		if marker == 0xda || segLen < 2 {
+			return 1
+		}
+		if marker != 0xe1 {
+			err = copyFull(io.Discard, br, segLen-2)
+			if err != nil {
+				return 1
+			}
+			continue
+		}
+
+		// APP1 segments are at most 64KB.
+		segment := make([]byte, segLen-2)
+		_, err = io.ReadFull(br, segment)
+		if err != nil {
+			return 1
+		}
+		if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+	}
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			break
+		}
+	}
+	return 1
+}
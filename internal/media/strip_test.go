@@ -0,0 +1,223 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// exifSegment returns an APP1 segment holding an EXIF block with a single
+// orientation entry.
+func exifSegment(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func jpegWith(segments ...[]byte) []byte {
+	src := []byte{0xff, 0xd8}
+	for _, segment := range segments {
+		src = append(src, segment...)
+	}
+	// A start of scan followed by some entropy-coded data and the end marker.
+	return append(src, 0xff, 0xda, 0x00, 0x02, 0x12, 0x34, 0xff, 0xd9)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		src  []byte
+		want int
+	}{
+		{"no exif", jpegWith(), 1},
+		{"little endian", jpegWith(exifSegment(binary.LittleEndian, 6)), 6},
+		{"big endian", jpegWith(exifSegment(binary.BigEndian, 8)), 8},
+		{"after another segment", jpegWith([]byte{0xff, 0xe0, 0x00, 0x04, 'J', 'F'}, exifSegment(binary.BigEndian, 3)), 3},
+		{"out of range orientation", jpegWith(exifSegment(binary.LittleEndian, 9)), 1},
+		{"zero segment length", []byte{0xff, 0xd8, 0xff, 0xe1, 0x00, 0x00, 'E', 'x', 'i', 'f'}, 1},
+		{"one byte segment length", []byte{0xff, 0xd8, 0xff, 0xe1, 0x00, 0x01, 'E', 'x', 'i', 'f'}, 1},
+		{"segment longer than file", []byte{0xff, 0xd8, 0xff, 0xe1, 0xff, 0xff, 'E', 'x', 'i', 'f'}, 1},
+		{"truncated exif", jpegWith(exifSegment(binary.LittleEndian, 6))[:20], 1},
+		{"empty", nil, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := JPEGOrientation(bytes.NewReader(tt.src))
+			if got != tt.want {
+				t.Errorf("got orientation %d; want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzJPEGOrientation(f *testing.F) {
+	f.Add(jpegWith(exifSegment(binary.LittleEndian, 6)))
+	f.Add(jpegWith(exifSegment(binary.BigEndian, 2)))
+	f.Add([]byte{0xff, 0xd8, 0xff, 0xe1, 0x00, 0x00})
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		got := JPEGOrientation(bytes.NewReader(src))
+		if got < 1 || got > 8 {
+			t.Errorf("got orientation %d; want 1 to 8", got)
+		}
+	})
+}
+
+// pngChunk returns a PNG chunk. The CRC is left as zero, as the stripper
+// doesn't check it.
+func pngChunk(chunkType string, payload []byte) []byte {
+	chunk := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk, uint32(len(payload)))
+	copy(chunk[4:], chunkType)
+	chunk = append(chunk, payload...)
+	return append(chunk, 0, 0, 0, 0)
+}
+
+func pngWith(chunks ...[]byte) []byte {
+	src := append([]byte(nil), pngSignature...)
+	for _, chunk := range chunks {
+		src = append(src, chunk...)
+	}
+	return src
+}
+
+// webpChunk returns a RIFF chunk, padded to an even length.
+func webpChunk(chunkType string, payload []byte) []byte {
+	chunk := make([]byte, 8, 9+len(payload))
+	copy(chunk, chunkType)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func webpWith(chunks ...[]byte) []byte {
+	src := []byte("RIFF\x00\x00\x00\x00WEBP")
+	for _, chunk := range chunks {
+		src = append(src, chunk...)
+	}
+	binary.LittleEndian.PutUint32(src[4:], uint32(len(src)-8))
+	return src
+}
+
+func stripMetadata(contentType string, src []byte) ([]byte, error) {
+	var dst bytes.Buffer
+	err := StripMetadata(&dst, contentType, bytes.NewReader(src))
+	return dst.Bytes(), err
+}
+
+func TestStripMetadata(t *testing.T) {
+	app0 := []byte{0xff, 0xe0, 0x00, 0x04, 'J', 'F'}
+	comment := []byte{0xff, 0xfe, 0x00, 0x05, 'h', 'i', '!'}
+	ihdr := pngChunk("IHDR", make([]byte, 13))
+	idat := pngChunk("IDAT", []byte("pixels"))
+	iend := pngChunk("IEND", nil)
+	vp8x := webpChunk("VP8X", []byte{webpFlagEXIF | webpFlagXMP | 0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	vp8xStripped := webpChunk("VP8X", []byte{0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	vp8 := webpChunk("VP8 ", []byte("frame"))
+
+	tests := []struct {
+		name        string
+		contentType string
+		src         []byte
+		want        []byte
+	}{
+		{"jpeg", TypeJPEG, jpegWith(app0, exifSegment(binary.LittleEndian, 6), comment), jpegWith(app0)},
+		{"jpeg without metadata", TypeJPEG, jpegWith(app0), jpegWith(app0)},
+		{"png", TypePNG, pngWith(ihdr, pngChunk("tEXt", []byte("Author\x00me")), pngChunk("eXIf", []byte("MM")), idat, iend), pngWith(ihdr, idat, iend)},
+		{"png with data after IEND", TypePNG, append(pngWith(ihdr, idat, iend), "trailing"...), pngWith(ihdr, idat, iend)},
+		{"webp", TypeWebP, webpWith(vp8x, vp8, webpChunk("EXIF", []byte("MM\x00*")), webpChunk("XMP ", []byte("<x/>"))), webpWith(vp8xStripped, vp8)},
+		{"gif", TypeGIF, []byte("GIF89a..."), []byte("GIF89a...")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stripMetadata(tt.contentType, tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripMetadataCorrupt(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		src         []byte
+	}{
+		{"jpeg without soi", TypeJPEG, []byte("not a jpeg")},
+		{"jpeg with short segment", TypeJPEG, []byte{0xff, 0xd8, 0xff, 0xe1, 0x00, 0x01, 0xff, 0xd9}},
+		{"jpeg segment past end", TypeJPEG, []byte{0xff, 0xd8, 0xff, 0xe1, 0x10, 0x00, 0xff, 0xd9}},
+		{"jpeg without sos", TypeJPEG, []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x02}},
+		{"png without signature", TypePNG, []byte("IHDR")},
+		{"png chunk past end", TypePNG, pngWith([]byte{0, 0, 1, 0, 'I', 'D', 'A', 'T'})},
+		{"truncated png chunk header", TypePNG, pngWith([]byte{0, 0, 0})},
+		{"webp without header", TypeWebP, []byte("RIFF")},
+		{"webp chunk past end", TypeWebP, webpWith([]byte("VP8 \xff\xff\x00\x00"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := stripMetadata(tt.contentType, tt.src)
+			if !errors.Is(err, ErrCorruptImage) {
+				t.Errorf("got error %v; want ErrCorruptImage", err)
+			}
+		})
+	}
+}
+
+// fuzzStripMetadata checks that stripping never panics, and that stripping
+// an already stripped image changes nothing.
+func fuzzStripMetadata(f *testing.F, contentType string, seeds ...[]byte) {
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, src []byte) {
+		stripped, err := stripMetadata(contentType, src)
+		if err != nil {
+			return
+		}
+		again, err := stripMetadata(contentType, stripped)
+		if err != nil {
+			t.Fatalf("stripping the stripped image: %v", err)
+		}
+		if !bytes.Equal(again, stripped) {
+			t.Errorf("stripping twice gave %q; want %q", again, stripped)
+		}
+	})
+}
+
+func FuzzStripJPEG(f *testing.F) {
+	fuzzStripMetadata(f, TypeJPEG, jpegWith(exifSegment(binary.BigEndian, 1)), jpegWith([]byte{0xff, 0xfe, 0x00, 0x03, 'x'}))
+}
+
+func FuzzStripPNG(f *testing.F) {
+	fuzzStripMetadata(f, TypePNG, pngWith(pngChunk("IHDR", make([]byte, 13)), pngChunk("tEXt", []byte("a\x00b")), pngChunk("IEND", nil)))
+}
+
+func FuzzStripWebP(f *testing.F) {
+	fuzzStripMetadata(f, TypeWebP, webpWith(webpChunk("VP8X", make([]byte, 10)), webpChunk("EXIF", []byte("II*\x00"))))
+}
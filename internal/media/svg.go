@@ -0,0 +1,126 @@
+package media
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var ErrInvalidSVG = errors.New("invalid SVG document")
+
+// unsafeSVGElements are dropped along with everything inside them.
+var unsafeSVGElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+	"handler":       true,
+	"listener":      true,
+	"style":         true,
+}
+
+var (
+	safeDataURLRX = regexp.MustCompile(`^data:image/(png|jpeg|gif|webp);base64,`)
+	externalURLRX = regexp.MustCompile(`(?i)url\(\s*['"]?\s*[^#'"\s)]`)
+)
+
+// SanitizeSVG re-serialises an SVG document keeping only markup that can't
+// run script or load external resources: scripts, event handler attributes,
+// external links and stylesheets are removed, as are comments, processing
+// instructions and DOCTYPEs. The result is written to dst as src is read, so
+// dst may hold a partial document if it fails, which it does if the document
+// isn't an SVG.
+func SanitizeSVG(dst io.Writer, src io.Reader) error {
+	dec := xml.NewDecoder(src)
+	dec.Strict = true
+
+	out := bufio.NewWriter(dst)
+	depth := 0
+	skipDepth := 0
+	sawRoot := false
+
+	for {
+		tok, err := dec.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return ErrInvalidSVG
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if !sawRoot {
+				if !strings.EqualFold(t.Name.Local, "svg") {
+					return ErrInvalidSVG
+				}
+				sawRoot = true
+			} else if depth == 1 {
+				return ErrInvalidSVG
+			}
+			if skipDepth > 0 {
+				continue
+			}
+			if unsafeSVGElements[strings.ToLower(t.Name.Local)] {
+				skipDepth = depth
+				continue
+			}
+			out.WriteString("<" + qualifiedName(t.Name))
+			for _, attr := range t.Attr {
+				if !safeSVGAttr(attr) {
+					continue
+				}
+				out.WriteString(" " + qualifiedName(attr.Name) + `="`)
+				xml.EscapeText(out, []byte(attr.Value))
+				out.WriteString(`"`)
+			}
+			out.WriteString(">")
+
+		case xml.EndElement:
+			if skipDepth == 0 {
+				out.WriteString("</" + qualifiedName(t.Name) + ">")
+			}
+			if depth == skipDepth {
+				skipDepth = 0
+			}
+			depth--
+
+		case xml.CharData:
+			if skipDepth == 0 && depth > 0 {
+				xml.EscapeText(out, t)
+			}
+		}
+	}
+
+	if !sawRoot || depth != 0 {
+		return ErrInvalidSVG
+	}
+	return out.Flush()
+}
+
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+func safeSVGAttr(attr xml.Attr) bool {
+	name := strings.ToLower(attr.Name.Local)
+	value := strings.ToLower(strings.Join(strings.Fields(attr.Value), ""))
+
+	switch {
+	case strings.HasPrefix(name, "on"):
+		return false
+	case name == "href" || name == "src":
+		return strings.HasPrefix(value, "#") || safeDataURLRX.MatchString(value)
+	case name == "style":
+		return !externalURLRX.MatchString(value) && !strings.Contains(value, "expression(")
+	}
+	return !strings.Contains(value, "javascript:") && !externalURLRX.MatchString(value)
+}
@@ -0,0 +1,103 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeSVG(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "safe markup kept",
+			src:  `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><rect width="10" height="10" fill="red"/><text>a &lt; b</text></svg>`,
+			want: `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><rect width="10" height="10" fill="red"></rect><text>a &lt; b</text></svg>`,
+		},
+		{
+			name: "script removed with its content",
+			src:  `<svg><script>alert(1)</script><g><script><![CDATA[alert(2)]]></script></g></svg>`,
+			want: `<svg><g></g></svg>`,
+		},
+		{
+			name: "unsafe elements matched case-insensitively",
+			src:  `<svg><foreignObject><iframe src="https://example.com"/></foreignObject><STYLE>*{}</STYLE></svg>`,
+			want: `<svg></svg>`,
+		},
+		{
+			name: "event handlers removed",
+			src:  `<svg onload="alert(1)"><rect ONCLICK="alert(2)" width="1"/></svg>`,
+			want: `<svg><rect width="1"></rect></svg>`,
+		},
+		{
+			name: "external links removed",
+			src:  `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><use xlink:href="https://example.com/a.svg#x"/><a href="javascript:alert(1)"/><image href="http://example.com/a.png"/></svg>`,
+			want: `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><use></use><a></a><image></image></svg>`,
+		},
+		{
+			name: "fragment and inline image links kept",
+			src:  `<svg><use href="#shape"/><image href="data:image/png;base64,AAAA"/></svg>`,
+			want: `<svg><use href="#shape"></use><image href="data:image/png;base64,AAAA"></image></svg>`,
+		},
+		{
+			name: "inline svg data links removed",
+			src:  `<svg><image href="data:image/svg+xml;base64,AAAA"/></svg>`,
+			want: `<svg><image></image></svg>`,
+		},
+		{
+			name: "external urls in styles removed",
+			src:  `<svg><rect style="fill: url( 'https://example.com/x' )"/><rect style="fill:url(#grad)"/><rect fill="url(http://example.com/x)"/></svg>`,
+			want: `<svg><rect></rect><rect style="fill:url(#grad)"></rect><rect></rect></svg>`,
+		},
+		{
+			name: "javascript urls removed despite whitespace",
+			src:  `<svg><a xlink:href="java&#x09;script:alert(1)"/></svg>`,
+			want: `<svg><a></a></svg>`,
+		},
+		{
+			name: "comments, processing instructions and doctype removed",
+			src:  `<?xml version="1.0"?><!DOCTYPE svg><!-- hi --><svg><?php echo 1 ?><!-- there --></svg>`,
+			want: `<svg></svg>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dst bytes.Buffer
+			err := SanitizeSVG(&dst, strings.NewReader(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := dst.String(); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeSVGInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"empty", ``},
+		{"not svg", `<html><body/></html>`},
+		{"second root", `<svg></svg><svg></svg>`},
+		{"unclosed", `<svg><g></svg>`},
+		{"truncated", `<svg><rect`},
+		{"undefined entity", `<!DOCTYPE svg [<!ENTITY x "y">]><svg>&x;</svg>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := SanitizeSVG(&bytes.Buffer{}, strings.NewReader(tt.src))
+			if !errors.Is(err, ErrInvalidSVG) {
+				t.Errorf("SanitizeSVG = %v; want ErrInvalidSVG", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,365 @@
+// Package metrics keeps counters, gauges and histograms and writes them in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, used for request latency
+// histograms.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds the metrics exposed by a process.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (reg *Registry) register(m metric) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for _, existing := range reg.metrics {
+		if existing.name() == m.name() {
+			panic("metrics: duplicate metric " + m.name())
+		}
+	}
+	reg.metrics = append(reg.metrics, m)
+}
+
+// Write writes every metric in the text exposition format, sorted by name.
+func (reg *Registry) Write(w io.Writer) error {
+	reg.mu.Lock()
+	metrics := make([]metric, len(reg.metrics))
+	copy(metrics, reg.metrics)
+	reg.mu.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// desc is the name, help text and label names shared by every series of a
+// metric.
+type desc struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+}
+
+func (d *desc) name() string {
+	return d.metricName
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.metricName, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.metricName, d.kind)
+}
+
+// labelPairs formats label names and values as the part of a sample line
+// between braces, without the braces.
+func labelPairs(names, values []string) string {
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// vec holds one series per combination of label values.
+type vec[T any] struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*T
+	values map[string][]string
+	newT   func() *T
+}
+
+func newVec[T any](d desc, newT func() *T) *vec[T] {
+	return &vec[T]{desc: d, series: map[string]*T{}, values: map[string][]string{}, newT: newT}
+}
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.metricName, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = v.newT()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+// each calls fn with the formatted labels of each series, in a stable order.
+func (v *vec[T]) each(fn func(labels []string, s *T)) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	series := make([]*T, len(keys))
+	values := make([][]string, len(keys))
+	sort.Strings(keys)
+	for i, key := range keys {
+		series[i] = v.series[key]
+		values[i] = v.values[key]
+	}
+	v.mu.Unlock()
+
+	for i := range keys {
+		fn(values[i], series[i])
+	}
+}
+
+// value is a float64 that is safe for concurrent use.
+type value struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (val *value) add(delta float64) {
+	val.mu.Lock()
+	val.v += delta
+	val.mu.Unlock()
+}
+
+func (val *value) set(v float64) {
+	val.mu.Lock()
+	val.v = v
+	val.mu.Unlock()
+}
+
+func (val *value) get() float64 {
+	val.mu.Lock()
+	defer val.mu.Unlock()
+	return val.v
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	value
+}
+
+func (c *Counter) Inc() {
+	c.add(1)
+}
+
+// Add adds delta, which must not be negative.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.add(delta)
+}
+
+type CounterVec struct {
+	*vec[Counter]
+}
+
+// Counter registers a counter with the given label names.
+func (reg *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(desc{name, help, "counter", labels}, func() *Counter { return &Counter{} })}
+	reg.register(c)
+	return c
+}
+
+// With returns the counter for the label values, in the order the label
+// names were registered in.
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.with(values)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.each(func(values []string, counter *Counter) {
+		writeSample(w, c.metricName, labelPairs(c.labels, values), counter.get())
+	})
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	value
+}
+
+func (g *Gauge) Set(v float64) {
+	g.set(v)
+}
+
+func (g *Gauge) Inc() {
+	g.add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.add(-1)
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.add(delta)
+}
+
+type GaugeVec struct {
+	*vec[Gauge]
+}
+
+// Gauge registers a gauge with the given label names.
+func (reg *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(desc{name, help, "gauge", labels}, func() *Gauge { return &Gauge{} })}
+	reg.register(g)
+	return g
+}
+
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.with(values)
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	g.each(func(values []string, gauge *Gauge) {
+		writeSample(w, g.metricName, labelPairs(g.labels, values), gauge.get())
+	})
+}
+
+// funcMetric is a gauge or counter whose value is read when the metrics are
+// written.
+type funcMetric struct {
+	desc
+	fn func() float64
+}
+
+// GaugeFunc registers a gauge whose value is fn's result at each scrape.
+func (reg *Registry) GaugeFunc(name, help string, fn func() float64) {
+	reg.register(&funcMetric{desc{name, help, "gauge", nil}, fn})
+}
+
+// CounterFunc registers a counter whose value is fn's result at each scrape,
+// for counters kept elsewhere such as sql.DBStats.WaitCount.
+func (reg *Registry) CounterFunc(name, help string, fn func() float64) {
+	reg.register(&funcMetric{desc{name, help, "counter", nil}, fn})
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	f.writeHeader(w)
+	writeSample(w, f.metricName, "", f.fn())
+}
+
+// Histogram counts observations into buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+type HistogramVec struct {
+	*vec[Histogram]
+	buckets []float64
+}
+
+// Histogram registers a histogram with the given bucket upper bounds, which
+// must be sorted, and label names.
+func (reg *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: histogram buckets must be sorted")
+	}
+	newHistogram := func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	}
+	h := &HistogramVec{newVec(desc{name, help, "histogram", labels}, newHistogram), buckets}
+	reg.register(h)
+	return h
+}
+
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.with(values)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.each(func(values []string, histogram *Histogram) {
+		histogram.mu.Lock()
+		counts := append([]uint64(nil), histogram.counts...)
+		sum, count := histogram.sum, histogram.count
+		histogram.mu.Unlock()
+
+		labels := labelPairs(h.labels, values)
+		sep := ""
+		if labels != "" {
+			sep = ","
+		}
+		cumulative := uint64(0)
+		for i, bound := range h.buckets {
+			cumulative += counts[i]
+			writeSample(w, h.metricName+"_bucket", labels+sep+`le="`+formatFloat(bound)+`"`, float64(cumulative))
+		}
+		writeSample(w, h.metricName+"_bucket", labels+sep+`le="+Inf"`, float64(count))
+		writeSample(w, h.metricName+"_sum", labels, sum)
+		writeSample(w, h.metricName+"_count", labels, float64(count))
+	})
+}
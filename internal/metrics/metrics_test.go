@@ -0,0 +1,216 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// parse writes the registry and reads it back with Prometheus' own parser,
+// which fails on anything a scraper would reject.
+func parse(t *testing.T, reg *Registry) map[string]*dto.MetricFamily {
+	t.Helper()
+	var buf bytes.Buffer
+	err := reg.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(&buf)
+	if err != nil {
+		t.Fatalf("parsing output: %v\n%s", err, buf.String())
+	}
+	return families
+}
+
+func labels(m *dto.Metric) map[string]string {
+	pairs := map[string]string{}
+	for _, pair := range m.GetLabel() {
+		pairs[pair.GetName()] = pair.GetValue()
+	}
+	return pairs
+}
+
+func TestCounterEscaping(t *testing.T) {
+	reg := NewRegistry()
+	help := `Requests with "odd" \ labels` + "\nover two lines."
+	c := reg.Counter("requests_total", help, "path", "status")
+	odd := `/a"b\c` + "\nd"
+	c.With(odd, "2xx").Inc()
+	c.With(odd, "2xx").Add(2)
+	c.With("/plain", "5xx").Inc()
+
+	family := parse(t, reg)["requests_total"]
+	if family == nil {
+		t.Fatal("requests_total missing")
+	}
+	if family.GetType() != dto.MetricType_COUNTER {
+		t.Errorf("type = %s; want COUNTER", family.GetType())
+	}
+	if family.GetHelp() != help {
+		t.Errorf("help = %q; want %q", family.GetHelp(), help)
+	}
+
+	got := map[string]float64{}
+	for _, m := range family.GetMetric() {
+		l := labels(m)
+		got[l["path"]+" "+l["status"]] = m.GetCounter().GetValue()
+	}
+	want := map[string]float64{odd + " 2xx": 3, "/plain 5xx": 1}
+	if len(got) != len(want) {
+		t.Fatalf("series = %v; want %v", got, want)
+	}
+	for key, v := range want {
+		if got[key] != v {
+			t.Errorf("series %q = %v; want %v", key, got[key], v)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels []string
+		values []string
+	}{
+		{"labelled", []string{"route"}, []string{"/v1/cards"}},
+		{"unlabelled", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := NewRegistry()
+			h := reg.Histogram("duration_seconds", "Durations.", []float64{1, 2, 5}, tt.labels...).With(tt.values...)
+			for _, v := range []float64{0.5, 1, 3, 10} {
+				h.Observe(v)
+			}
+
+			family := parse(t, reg)["duration_seconds"]
+			if family == nil || len(family.GetMetric()) != 1 {
+				t.Fatalf("duration_seconds = %v; want one series", family)
+			}
+			if family.GetType() != dto.MetricType_HISTOGRAM {
+				t.Errorf("type = %s; want HISTOGRAM", family.GetType())
+			}
+			m := family.GetMetric()[0]
+			for i, name := range tt.labels {
+				if labels(m)[name] != tt.values[i] {
+					t.Errorf("labels = %v; want %s=%q", labels(m), name, tt.values[i])
+				}
+			}
+
+			hist := m.GetHistogram()
+			if hist.GetSampleCount() != 4 {
+				t.Errorf("count = %d; want 4", hist.GetSampleCount())
+			}
+			if hist.GetSampleSum() != 14.5 {
+				t.Errorf("sum = %v; want 14.5", hist.GetSampleSum())
+			}
+
+			// Buckets are cumulative, an observation equal to a bound falls
+			// in that bound's bucket, and the +Inf bucket counts everything.
+			want := []struct {
+				bound float64
+				count uint64
+			}{{1, 2}, {2, 2}, {5, 3}, {math.Inf(1), 4}}
+			buckets := hist.GetBucket()
+			if len(buckets) != len(want) {
+				t.Fatalf("got %d buckets; want %d", len(buckets), len(want))
+			}
+			for i, b := range buckets {
+				if b.GetUpperBound() != want[i].bound || b.GetCumulativeCount() != want[i].count {
+					t.Errorf("bucket %d = le %v: %d; want le %v: %d", i, b.GetUpperBound(), b.GetCumulativeCount(), want[i].bound, want[i].count)
+				}
+			}
+		})
+	}
+}
+
+func TestHistogramInfBucket(t *testing.T) {
+	reg := NewRegistry()
+	reg.Histogram("size_bytes", "Sizes.", []float64{10}).With().Observe(100)
+
+	var buf bytes.Buffer
+	err := reg.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`size_bytes_bucket{le="10"} 0`,
+		`size_bytes_bucket{le="+Inf"} 1`,
+		`size_bytes_sum 100`,
+		`size_bytes_count 1`,
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(line+"\n")) {
+			t.Errorf("output is missing %q:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestGauges(t *testing.T) {
+	reg := NewRegistry()
+	g := reg.Gauge("in_flight", "In flight.").With()
+	g.Inc()
+	g.Inc()
+	g.Dec()
+
+	calls := 0
+	reg.GaugeFunc("due", "Due.", func() float64 {
+		calls++
+		return 42
+	})
+	reg.GaugeFunc("unknown", "Unknown.", func() float64 { return math.NaN() })
+	reg.CounterFunc("waits_total", "Waits.", func() float64 { return 7 })
+
+	families := parse(t, reg)
+	tests := []struct {
+		name  string
+		kind  dto.MetricType
+		value float64
+	}{
+		{"in_flight", dto.MetricType_GAUGE, 1},
+		{"due", dto.MetricType_GAUGE, 42},
+		{"waits_total", dto.MetricType_COUNTER, 7},
+	}
+	for _, tt := range tests {
+		family := families[tt.name]
+		if family == nil || len(family.GetMetric()) != 1 {
+			t.Errorf("%s = %v; want one series", tt.name, family)
+			continue
+		}
+		if family.GetType() != tt.kind {
+			t.Errorf("%s type = %s; want %s", tt.name, family.GetType(), tt.kind)
+		}
+		m := family.GetMetric()[0]
+		got := m.GetGauge().GetValue()
+		if tt.kind == dto.MetricType_COUNTER {
+			got = m.GetCounter().GetValue()
+		}
+		if got != tt.value {
+			t.Errorf("%s = %v; want %v", tt.name, got, tt.value)
+		}
+	}
+	if v := families["unknown"].GetMetric()[0].GetGauge().GetValue(); !math.IsNaN(v) {
+		t.Errorf("unknown = %v; want NaN", v)
+	}
+
+	// Func gauges are read at every scrape.
+	parse(t, reg)
+	if calls != 2 {
+		t.Errorf("fn called %d times over 2 scrapes; want 2", calls)
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	reg := NewRegistry()
+	reg.Counter("requests_total", "Requests.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a duplicate name didn't panic")
+		}
+	}()
+	reg.Gauge("requests_total", "Requests.")
+}
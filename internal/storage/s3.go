@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3Config configures an S3Store. Endpoint is only needed for S3-compatible
+// services such as MinIO, which usually also need PathStyle. PublicURL
+// overrides the URL blobs are linked from, e.g. for a CDN in front of the
+// bucket.
+type S3Config struct {
+	Region    string
+	Bucket    string
+	Endpoint  string
+	PathStyle bool
+	PublicURL string
+	ACL       string
+}
+
+// S3Store keeps blobs in an S3 bucket. Credentials come from the SDK's
+// default chain, e.g. AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+type S3Store struct {
+	Client *s3.S3
+	Config S3Config
+}
+
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3 storage needs a bucket")
+	}
+	awsConfig := &aws.Config{
+		Region:           aws.String(cfg.Region),
+		S3ForcePathStyle: aws.Bool(cfg.PathStyle),
+	}
+	if cfg.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(cfg.Endpoint)
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Store{Client: s3.New(sess), Config: cfg}, nil
+}
+
+func (s *S3Store) Put(key string, body io.ReadSeeker, contentType string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.Config.Bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	if s.Config.ACL != "" {
+		input.ACL = aws.String(s.Config.ACL)
+	}
+	_, err := s.Client.PutObject(input)
+	return err
+}
+
+func (s *S3Store) Get(key string) (io.ReadCloser, Info, error) {
+	if !ValidKey(key) {
+		return nil, Info{}, ErrInvalidKey
+	}
+	out, err := s.Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.Config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, Info{}, s3Error(err)
+	}
+	info := Info{
+		Size:        aws.Int64Value(out.ContentLength),
+		ContentType: aws.StringValue(out.ContentType),
+		ModTime:     aws.TimeValue(out.LastModified),
+	}
+	return out.Body, info, nil
+}
+
+func (s *S3Store) Stat(key string) (Info, error) {
+	if !ValidKey(key) {
+		return Info{}, ErrInvalidKey
+	}
+	out, err := s.Client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.Config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return Info{}, s3Error(err)
+	}
+	return Info{
+		Size:        aws.Int64Value(out.ContentLength),
+		ContentType: aws.StringValue(out.ContentType),
+		ModTime:     aws.TimeValue(out.LastModified),
+	}, nil
+}
+
+func (s *S3Store) Delete(key string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	_, err := s.Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.Config.Bucket),
+		Key:    aws.String(key),
+	})
+	return s3Error(err)
+}
+
+func (s *S3Store) URL(key string) string {
+	key = escapeKey(key)
+	if s.Config.PublicURL != "" {
+		return strings.TrimSuffix(s.Config.PublicURL, "/") + "/" + key
+	}
+	if s.Config.Endpoint != "" {
+		u, err := url.Parse(s.Config.Endpoint)
+		if err == nil && u.Host != "" {
+			if s.Config.PathStyle {
+				return fmt.Sprintf("%s://%s/%s/%s", u.Scheme, u.Host, s.Config.Bucket, key)
+			}
+			return fmt.Sprintf("%s://%s.%s/%s", u.Scheme, s.Config.Bucket, u.Host, key)
+		}
+	}
+	if s.Config.PathStyle {
+		return fmt.Sprintf("https://s3.%s.amazonaws.com/%s/%s", s.Config.Region, s.Config.Bucket, key)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.Config.Bucket, s.Config.Region, key)
+}
+
+func (s *S3Store) PresignPut(key, contentType string, size int64, sha256 []byte, expiry time.Duration) (*PresignedUpload, error) {
+	if !ValidKey(key) {
+		return nil, ErrInvalidKey
+	}
+	input := &s3.PutObjectInput{
+		Bucket:         aws.String(s.Config.Bucket),
+		Key:            aws.String(key),
+		ContentType:    aws.String(contentType),
+		ContentLength:  aws.Int64(size),
+		ChecksumSHA256: aws.String(base64.StdEncoding.EncodeToString(sha256)),
+	}
+	if s.Config.ACL != "" {
+		input.ACL = aws.String(s.Config.ACL)
+	}
+	req, _ := s.Client.PutObjectRequest(input)
+	signedURL, header, err := req.PresignRequest(expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	// The SDK keys these by their lowercase signed names, so Get can't be
+	// used to read them.
+	headers := make(map[string]string, len(header))
+	for name, values := range header {
+		headers[name] = strings.Join(values, ",")
+	}
+	return &PresignedUpload{
+		Method:    http.MethodPut,
+		URL:       signedURL,
+		Headers:   headers,
+		ExpiresAt: time.Now().Add(expiry),
+	}, nil
+}
+
+// s3Error maps the SDK's not-found errors to ErrNotFound. HeadObject has no
+// response body, so it only reports the status code.
+func s3Error(err error) error {
+	var requestErr awserr.RequestFailure
+	if errors.As(err, &requestErr) && requestErr.StatusCode() == http.StatusNotFound {
+		return ErrNotFound
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return ErrNotFound
+	}
+	return err
+}
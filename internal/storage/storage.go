@@ -0,0 +1,68 @@
+// Package storage stores uploaded files behind a common BlobStore interface,
+// backed by the local filesystem or by S3 and S3-compatible services.
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Info describes a stored blob.
+type Info struct {
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// BlobStore stores blobs under slash-separated keys such as
+// "images/ab/abcdef.png".
+type BlobStore interface {
+	// Put stores the body under key, replacing any existing blob.
+	Put(key string, body io.ReadSeeker, contentType string) error
+	// Get opens the blob for reading. The caller must close it.
+	Get(key string) (io.ReadCloser, Info, error)
+	// Stat returns the blob's metadata, or ErrNotFound.
+	Stat(key string) (Info, error)
+	// Delete removes the blob. Deleting a missing blob is not an error.
+	Delete(key string) error
+	// URL returns the public URL the blob is served from.
+	URL(key string) string
+}
+
+// PresignedUpload is a request a client can make to upload a blob straight
+// to the store, without the file passing through the API.
+type PresignedUpload struct {
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// Presigner is implemented by stores that clients can upload to directly.
+type Presigner interface {
+	// PresignPut returns a request, valid until expiry, that stores a blob
+	// under key. The store rejects the upload unless it has exactly the
+	// given size, SHA-256 checksum and content type.
+	PresignPut(key, contentType string, size int64, sha256 []byte, expiry time.Duration) (*PresignedUpload, error)
+}
+
+// ValidKey reports whether key is a relative, slash-separated path with no
+// empty, "." or ".." segments, so that it can't escape the store's root.
+func ValidKey(key string) bool {
+	if key == "" || len(key) > 1024 || strings.ContainsAny(key, "\\\x00") {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,2 @@
+ALTER TABLE cards
+DROP COLUMN version;
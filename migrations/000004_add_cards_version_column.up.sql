@@ -0,0 +1,2 @@
+ALTER TABLE cards
+ADD COLUMN version integer NOT NULL DEFAULT 1;
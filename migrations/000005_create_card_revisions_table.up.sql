@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS card_revisions (
+    card_id bigint NOT NULL REFERENCES cards ON DELETE CASCADE,
+    version integer NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    title text NOT NULL,
+    tags text[] NOT NULL,
+    content text NOT NULL,
+    next_review_date date NOT NULL,
+    code_snippet jsonb,
+    description text,
+    PRIMARY KEY (card_id, version)
+);
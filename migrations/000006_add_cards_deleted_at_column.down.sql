@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS cards_deleted_at_idx;
+
+ALTER TABLE cards
+DROP COLUMN deleted_at;
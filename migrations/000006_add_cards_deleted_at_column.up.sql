@@ -0,0 +1,4 @@
+ALTER TABLE cards
+ADD COLUMN deleted_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS cards_deleted_at_idx ON cards (deleted_at) WHERE deleted_at IS NOT NULL;
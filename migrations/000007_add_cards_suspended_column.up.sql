@@ -0,0 +1,2 @@
+ALTER TABLE cards
+ADD COLUMN suspended boolean NOT NULL DEFAULT false;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS card_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    type text NOT NULL,
+    card_id bigint NOT NULL,
+    data jsonb NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS card_events_created_at_idx ON card_events (created_at);
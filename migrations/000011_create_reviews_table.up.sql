@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    client_id text NOT NULL UNIQUE,
+    card_id bigint NOT NULL REFERENCES cards ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    reviewed_at timestamp(0) with time zone NOT NULL,
+    grade smallint,
+    next_review_date date NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS reviews_card_id_idx ON reviews (card_id, reviewed_at);
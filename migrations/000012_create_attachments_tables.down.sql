@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS card_attachments;
+DROP TABLE IF EXISTS attachments;
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS attachments (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    key text NOT NULL UNIQUE,
+    content_type text NOT NULL,
+    size bigint NOT NULL,
+    sha256 text NOT NULL,
+    uploaded_by text NOT NULL DEFAULT '',
+    variants jsonb NOT NULL DEFAULT '{}',
+    keys text[] NOT NULL,
+    unlinked_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS attachments_keys_idx ON attachments USING GIN (keys);
+
+CREATE TABLE IF NOT EXISTS card_attachments (
+    card_id bigint NOT NULL REFERENCES cards ON DELETE CASCADE,
+    attachment_id bigint NOT NULL REFERENCES attachments ON DELETE CASCADE,
+    PRIMARY KEY (card_id, attachment_id)
+);
+
+CREATE INDEX IF NOT EXISTS card_attachments_attachment_id_idx ON card_attachments (attachment_id);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS uploads (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expires_at timestamp(0) with time zone NOT NULL,
+    key text NOT NULL,
+    content_type text NOT NULL,
+    size bigint NOT NULL,
+    sha256 text NOT NULL,
+    uploaded_by text NOT NULL DEFAULT '',
+    status text NOT NULL DEFAULT 'pending',
+    attachment_id bigint REFERENCES attachments ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS uploads_expires_at_idx ON uploads (expires_at);
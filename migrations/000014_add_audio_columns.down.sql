@@ -0,0 +1,6 @@
+ALTER TABLE card_revisions
+DROP COLUMN audio;
+ALTER TABLE cards
+DROP COLUMN audio;
+ALTER TABLE attachments
+DROP COLUMN duration_ms;
@@ -0,0 +1,6 @@
+ALTER TABLE attachments
+ADD COLUMN duration_ms bigint;
+ALTER TABLE cards
+ADD COLUMN audio jsonb;
+ALTER TABLE card_revisions
+ADD COLUMN audio jsonb;
@@ -0,0 +1,4 @@
+ALTER TABLE card_revisions
+DROP COLUMN occlusion;
+ALTER TABLE cards
+DROP COLUMN occlusion;
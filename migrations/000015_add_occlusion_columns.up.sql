@@ -0,0 +1,4 @@
+ALTER TABLE cards
+ADD COLUMN occlusion jsonb;
+ALTER TABLE card_revisions
+ADD COLUMN occlusion jsonb;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS card_events_txid_id_idx;
+ALTER TABLE card_events
+DROP COLUMN txid;
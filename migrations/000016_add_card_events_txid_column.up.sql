@@ -0,0 +1,3 @@
+ALTER TABLE card_events
+ADD COLUMN txid bigint NOT NULL DEFAULT pg_current_xact_id()::text::bigint;
+CREATE INDEX IF NOT EXISTS card_events_txid_id_idx ON card_events (txid, id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS reviews_txid_id_idx;
+ALTER TABLE reviews
+DROP COLUMN txid;
@@ -0,0 +1,5 @@
+ALTER TABLE reviews
+ADD COLUMN txid bigint NOT NULL DEFAULT 0;
+ALTER TABLE reviews
+ALTER COLUMN txid SET DEFAULT pg_current_xact_id()::text::bigint;
+CREATE INDEX IF NOT EXISTS reviews_txid_id_idx ON reviews (txid, id);
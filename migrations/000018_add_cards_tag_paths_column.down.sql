@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS cards_tag_paths_idx;
+ALTER TABLE cards
+DROP COLUMN tag_paths;
+DROP FUNCTION IF EXISTS tag_paths(text[]);
@@ -0,0 +1,10 @@
+CREATE OR REPLACE FUNCTION tag_paths(tags text[]) RETURNS text[]
+LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+    SELECT coalesce(array_agg(DISTINCT array_to_string((string_to_array(t.tag, '::'))[1:n], '::')), '{}')
+    FROM unnest(tags) AS t(tag),
+        generate_series(1, cardinality(string_to_array(t.tag, '::'))) AS n
+$$;
+
+ALTER TABLE cards
+ADD COLUMN tag_paths text[] NOT NULL GENERATED ALWAYS AS (tag_paths(tags)) STORED;
+CREATE INDEX IF NOT EXISTS cards_tag_paths_idx ON cards USING GIN (tag_paths);
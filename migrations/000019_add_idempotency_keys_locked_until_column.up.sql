@@ -0,0 +1,4 @@
+ALTER TABLE idempotency_keys
+ADD COLUMN IF NOT EXISTS locked_until timestamp with time zone NOT NULL DEFAULT NOW();
+ALTER TABLE idempotency_keys
+ALTER COLUMN locked_until DROP DEFAULT;